@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/byuoitav/qsc-control/device"
+)
+
+func loadConfig(path string) (device.Config, error) {
+	var config device.Config
+	if path == "" {
+		return config, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config, fmt.Errorf("unable to open config: %w", err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return config, fmt.Errorf("unable to decode config: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
@@ -2,28 +2,40 @@ package main
 
 import (
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"github.com/byuoitav/qsc-control/device"
 	"github.com/gin-contrib/cors"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/pflag"
+	"go.uber.org/zap"
 )
 
 func main() {
-	var port, logLevel string
+	var port, logLevel, configPath string
 	pflag.StringVarP(&port, "port", "p", "8016", "port on which to host the control service")
 	pflag.StringVarP(&logLevel, "log", "l", "Info", "initial log level")
+	pflag.StringVarP(&configPath, "config", "c", "", "path to a json file with per-dsp configuration")
 	pflag.Parse()
 
 	port = ":" + port
 
 	log, logLvl := buildLogger(logLevel)
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal("unable to load config", zap.Error(err))
+	}
+
 	manager := device.DeviceManager{
 		Log:      log,
 		LogLevel: logLvl,
 		DspList:  &sync.Map{},
+		Config:   config,
 	}
 
 	router := gin.Default()
@@ -53,7 +65,18 @@ func main() {
 		ctx.String(http.StatusOK, manager.Log.Level().String())
 	})
 
-	err := manager.RunHTTPServer(router, port)
+	// close the connections to every core before exiting
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		manager.Log.Info("shutting down")
+		manager.Close()
+		os.Exit(0)
+	}()
+
+	err = manager.RunHTTPServer(router, port)
 	if err != nil {
 		manager.Log.Panic("http server failed")
 	}
@@ -0,0 +1,163 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ControlSet is a single control to set in a bulk set.
+type ControlSet struct {
+	// Component is the component the control belongs to. Named controls leave it empty.
+	Component string `json:"component,omitempty"`
+	Control   string `json:"control"`
+
+	// Value is a number, string or boolean
+	Value interface{} `json:"value"`
+
+	// Ramp is how many seconds the core takes to move the control to Value
+	Ramp float64 `json:"ramp,omitempty"`
+}
+
+// ControlSetResult is the result of a single set in a bulk set.
+type ControlSetResult struct {
+	ControlSet
+
+	// Status is the http status code that describes the result of this set
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   int    `json:"code,omitempty"`
+}
+
+func (dm *DeviceManager) HandlerSetControls(ctx *gin.Context) {
+	addr := ctx.Param("address")
+
+	var sets []ControlSet
+	if err := ctx.ShouldBindJSON(&sets); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	if len(sets) == 0 {
+		writeError(ctx, fmt.Errorf("%w: at least one control must be set", ErrInvalidParams))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("setting controls", zap.String("address", addr), zap.Int("count", len(sets)))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	errs := dsp.SetControls(c, sets)
+
+	results := make([]ControlSetResult, len(sets))
+	failed := 0
+	for i, set := range sets {
+		results[i] = ControlSetResult{
+			ControlSet: set,
+			Status:     http.StatusOK,
+		}
+
+		if errs[i] != nil {
+			failed++
+			resp := newErrorResponse(errs[i])
+			results[i].Status = httpStatus(errs[i])
+			results[i].Error = resp.Error
+			results[i].Code = resp.Code
+		}
+	}
+
+	if failed > 0 {
+		dm.Log.Warn("unable to set some controls", zap.String("address", addr), zap.Int("failed", failed), zap.Int("count", len(sets)))
+	}
+
+	dm.Log.Debug("Set controls", zap.String("address", addr))
+	ctx.JSON(http.StatusOK, results)
+}
+
+// SetControls sets many named and component controls at once.
+// The sets are written to the core in order without waiting for each response, so they are applied in the
+// order given in about one round trip. The error for each set is returned at the same index as the set;
+// a failed set doesn't stop the others.
+func (d *DSP) SetControls(ctx context.Context, sets []ControlSet) []error {
+	errs := make([]error, len(sets))
+	waits := make([]func(context.Context) error, len(sets))
+
+	for i, set := range sets {
+		if set.Control == "" {
+			errs[i] = fmt.Errorf("%w: control is required", ErrInvalidParams)
+			continue
+		}
+
+		if set.Ramp < 0 {
+			errs[i] = fmt.Errorf("%w: ramp must not be negative", ErrInvalidParams)
+			continue
+		}
+
+		waits[i], errs[i] = d.startSet(ctx, set)
+	}
+
+	for i, wait := range waits {
+		if wait != nil {
+			errs[i] = wait(ctx)
+		}
+	}
+
+	return errs
+}
+
+// startSet sends a single set from SetControls, and returns a function that waits for its result.
+func (d *DSP) startSet(ctx context.Context, set ControlSet) (func(context.Context) error, error) {
+	ramp := time.Duration(set.Ramp * float64(time.Second))
+	if set.Component != "" {
+		return d.startComponentControl(ctx, set.Component, set.Control, set.Value, ramp)
+	}
+
+	wait, err := d.startRampControl(ctx, set.Control, set.Value, ramp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		_, err := wait(ctx)
+		return err
+	}, nil
+}
+
+// startComponentControl sends a Component.Set that moves a single control of a component to value over the ramp time,
+// and returns a function that waits for its result.
+func (d *DSP) startComponentControl(ctx context.Context, component, name string, value interface{}, ramp time.Duration) (func(context.Context) error, error) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	req := d.GetGenericComponentSetRequest(ctx)
+	req.Params.Name = component
+	req.Params.Controls = []QSCSetStatusParams{{Name: name, Value: value, Ramp: ramp.Seconds()}}
+
+	d.log.Info("Setting component control", zap.String("component", component), zap.String("name", name), zap.Any("value", value), zap.Duration("ramp", ramp))
+
+	p, err := d.client.Start(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		if _, err := p.Wait(ctx); err != nil {
+			return err
+		}
+
+		// ramping controls are left for the change group to follow, see RampControl
+		if ramp <= 0 {
+			d.changes.set(component, name, value)
+		}
+
+		return nil
+	}, nil
+}
@@ -0,0 +1,622 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// QSCChangeGroupRequest is for every ChangeGroup method
+type QSCChangeGroupRequest struct {
+	BaseRequest
+	Params QSCChangeGroupParams `json:"params"`
+}
+
+// QSCChangeGroupParams is the parameters for the ChangeGroup methods.
+// Each method only uses some of them.
+type QSCChangeGroupParams struct {
+	ID        string                        `json:"Id"`
+	Controls  []string                      `json:",omitempty"`
+	Component *QSCChangeGroupComponentParam `json:",omitempty"`
+	Rate      float64                       `json:",omitempty"`
+}
+
+// QSCChangeGroupComponentParam is the component added by ChangeGroup.AddComponentControl
+type QSCChangeGroupComponentParam struct {
+	Name     string
+	Controls []QSCComponentControl
+}
+
+// QSCChangeGroupPollResponse is the response to the ChangeGroup.Poll method
+type QSCChangeGroupPollResponse struct {
+	BaseResponse
+	Result QSCChangeGroupPollResult `json:"result"`
+}
+
+// QSCChangeGroupPollResult is every control that changed since the last poll.
+// It is also the params of the ChangeGroup.Poll notifications the core sends after AutoPoll.
+type QSCChangeGroupPollResult struct {
+	ID      string `json:"Id"`
+	Changes []QSCChange
+}
+
+// QSCChange is the new state of a control in a change group.
+// Component is empty for named controls.
+type QSCChange struct {
+	Component string `json:",omitempty"`
+	Name      string
+	Value     float64
+	String    string
+	Position  float64
+}
+
+// UnmarshalJSON accepts changes to text and toggle controls, whose values aren't numbers.
+func (c *QSCChange) UnmarshalJSON(data []byte) error {
+	type change QSCChange
+	return unmarshalControl(data, (*change)(c), &c.Value, &c.String, nil)
+}
+
+type controlKey struct {
+	component string
+	name      string
+}
+
+const _changeGroupID = "qsc-control"
+
+// ChangeGroup keeps the current value of a set of controls up to date using a QRC change group.
+// It uses its own connection to the core so that AutoPoll updates never wait behind other requests,
+// and it recreates the group on the core whenever that connection is reopened.
+type ChangeGroup struct {
+	client   *client
+	log      *zap.Logger
+	onChange func([]QSCChange)
+
+	// mu guards the controls that should be in the group and the connection it exists on
+	mu         sync.Mutex
+	rate       time.Duration
+	controls   map[string]struct{}
+	components map[string]map[string]struct{}
+	conn       *clientConn
+
+	// valuesMu guards the latest value of each control, which is updated by the connection's reader
+	valuesMu sync.RWMutex
+	values   map[controlKey]QSCChange
+}
+
+func newChangeGroup(addr string, options options, notify notifyFunc, onChange func([]QSCChange)) *ChangeGroup {
+	cg := &ChangeGroup{
+		log:        options.logger.With(zap.String("changeGroup", _changeGroupID)),
+		onChange:   onChange,
+		rate:       options.pollRate,
+		controls:   make(map[string]struct{}),
+		components: make(map[string]map[string]struct{}),
+		values:     make(map[controlKey]QSCChange),
+	}
+
+	// the change group only exists as long as its connection, so it is only closed once the group is empty
+	options.ttl = 0
+	cg.client = newClient(addr, options, func(method string, params json.RawMessage) {
+		if method != "ChangeGroup.Poll" {
+			notify(method, params)
+			return
+		}
+
+		var result QSCChangeGroupPollResult
+		if err := json.Unmarshal(params, &result); err != nil {
+			cg.log.Warn("unable to parse change group poll", zap.ByteString("params", params), zap.Error(err))
+			return
+		}
+
+		cg.update(result.Changes)
+	})
+
+	return cg
+}
+
+func (cg *ChangeGroup) newRequest(method string) QSCChangeGroupRequest {
+	return QSCChangeGroupRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: cg.client.nextID(), Method: method}, Params: QSCChangeGroupParams{ID: _changeGroupID}}
+}
+
+// Value returns the latest value of a control in the change group.
+// component should be empty for named controls.
+// ok is false if the control isn't in the group or the core hasn't sent its value yet.
+func (cg *ChangeGroup) Value(component, name string) (QSCChange, bool) {
+	cg.valuesMu.RLock()
+	defer cg.valuesMu.RUnlock()
+
+	val, ok := cg.values[controlKey{component: component, name: name}]
+	return val, ok
+}
+
+// Values returns the latest value of every control in the change group.
+func (cg *ChangeGroup) Values() []QSCChange {
+	cg.valuesMu.RLock()
+	defer cg.valuesMu.RUnlock()
+
+	vals := make([]QSCChange, 0, len(cg.values))
+	for _, val := range cg.values {
+		vals = append(vals, val)
+	}
+
+	return vals
+}
+
+// AddControl adds named controls to the change group.
+func (cg *ChangeGroup) AddControl(ctx context.Context, names ...string) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	var toAdd []string
+	for _, name := range names {
+		if _, ok := cg.controls[name]; !ok {
+			cg.controls[name] = struct{}{}
+			toAdd = append(toAdd, name)
+		}
+	}
+
+	if len(toAdd) == 0 {
+		return nil
+	}
+
+	conn, fresh, err := cg.connection(ctx)
+	if err == nil && !fresh {
+		req := cg.newRequest("ChangeGroup.AddControl")
+		req.Params.Controls = toAdd
+
+		cg.log.Info("Adding controls to change group", zap.Strings("controls", toAdd))
+		_, err = cg.client.do(ctx, conn, req)
+	}
+
+	if err != nil {
+		for _, name := range toAdd {
+			delete(cg.controls, name)
+		}
+
+		return fmt.Errorf("unable to add controls to change group: %w", err)
+	}
+
+	return nil
+}
+
+// AddComponentControl adds controls of the named component to the change group.
+func (cg *ChangeGroup) AddComponentControl(ctx context.Context, component string, controls ...string) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	existing, ok := cg.components[component]
+	if !ok {
+		existing = make(map[string]struct{})
+		cg.components[component] = existing
+	}
+
+	var toAdd []string
+	for _, name := range controls {
+		if _, ok := existing[name]; !ok {
+			existing[name] = struct{}{}
+			toAdd = append(toAdd, name)
+		}
+	}
+
+	if len(toAdd) == 0 {
+		return nil
+	}
+
+	conn, fresh, err := cg.connection(ctx)
+	if err == nil && !fresh {
+		req := cg.newRequest("ChangeGroup.AddComponentControl")
+		req.Params.Component = componentParam(component, toAdd)
+
+		cg.log.Info("Adding component controls to change group", zap.String("component", component), zap.Strings("controls", toAdd))
+		_, err = cg.client.do(ctx, conn, req)
+	}
+
+	if err != nil {
+		for _, name := range toAdd {
+			delete(existing, name)
+		}
+
+		if len(existing) == 0 {
+			delete(cg.components, component)
+		}
+
+		return fmt.Errorf("unable to add component controls to change group: %w", err)
+	}
+
+	return nil
+}
+
+// Remove removes named controls from the change group.
+func (cg *ChangeGroup) Remove(ctx context.Context, names ...string) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	var toRemove []string
+	for _, name := range names {
+		if _, ok := cg.controls[name]; ok {
+			delete(cg.controls, name)
+			toRemove = append(toRemove, name)
+		}
+	}
+
+	if len(toRemove) == 0 {
+		return nil
+	}
+
+	cg.valuesMu.Lock()
+	for _, name := range toRemove {
+		delete(cg.values, controlKey{name: name})
+	}
+	cg.valuesMu.Unlock()
+
+	if cg.closeIfEmpty() || !cg.connected() {
+		return nil
+	}
+
+	req := cg.newRequest("ChangeGroup.Remove")
+	req.Params.Controls = toRemove
+
+	cg.log.Info("Removing controls from change group", zap.Strings("controls", toRemove))
+	if _, err := cg.client.do(ctx, cg.conn, req); err != nil {
+		return fmt.Errorf("unable to remove controls from change group: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveComponentControl removes controls of the named component from the change group.
+// QRC can't remove component controls from a change group, so the group is destroyed and created again without them.
+func (cg *ChangeGroup) RemoveComponentControl(ctx context.Context, component string, controls ...string) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	existing, ok := cg.components[component]
+	if !ok {
+		return nil
+	}
+
+	removed := false
+	cg.valuesMu.Lock()
+	for _, name := range controls {
+		if _, ok := existing[name]; ok {
+			delete(existing, name)
+			delete(cg.values, controlKey{component: component, name: name})
+			removed = true
+		}
+	}
+	cg.valuesMu.Unlock()
+
+	if len(existing) == 0 {
+		delete(cg.components, component)
+	}
+
+	if !removed || cg.closeIfEmpty() || !cg.connected() {
+		return nil
+	}
+
+	cg.log.Info("Removing component controls from change group", zap.String("component", component), zap.Strings("controls", controls))
+	if err := cg.destroy(ctx); err != nil {
+		return fmt.Errorf("unable to remove component controls from change group: %w", err)
+	}
+
+	if err := cg.setup(ctx, cg.conn); err != nil {
+		return fmt.Errorf("unable to remove component controls from change group: %w", err)
+	}
+
+	return nil
+}
+
+// Poll returns every control that has changed since the last poll.
+// It is only needed when AutoPoll is disabled.
+func (cg *ChangeGroup) Poll(ctx context.Context) ([]QSCChange, error) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	conn, _, err := cg.connection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to poll change group: %w", err)
+	}
+
+	resp, err := cg.client.do(ctx, conn, cg.newRequest("ChangeGroup.Poll"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to poll change group: %w", err)
+	}
+
+	qscResp := QSCChangeGroupPollResponse{}
+	if err := json.Unmarshal(resp, &qscResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %w", err)
+	}
+
+	cg.update(qscResp.Result.Changes)
+	return qscResp.Result.Changes, nil
+}
+
+// AutoPoll changes how often the core sends changes to the change group.
+func (cg *ChangeGroup) AutoPoll(ctx context.Context, rate time.Duration) error {
+	if rate <= 0 {
+		return fmt.Errorf("%w: auto poll rate must be positive", ErrInvalidParams)
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.rate = rate
+	if !cg.connected() {
+		return nil
+	}
+
+	if err := cg.autoPoll(ctx, cg.conn); err != nil {
+		return fmt.Errorf("unable to set change group auto poll: %w", err)
+	}
+
+	return nil
+}
+
+// Invalidate makes the core send the value of every control in the change group on the next poll.
+func (cg *ChangeGroup) Invalidate(ctx context.Context) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if !cg.connected() {
+		return nil
+	}
+
+	cg.log.Info("Invalidating change group")
+	if _, err := cg.client.do(ctx, cg.conn, cg.newRequest("ChangeGroup.Invalidate")); err != nil {
+		return fmt.Errorf("unable to invalidate change group: %w", err)
+	}
+
+	return nil
+}
+
+// Destroy removes every control from the change group and destroys it on the core.
+func (cg *ChangeGroup) Destroy(ctx context.Context) error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	cg.controls = make(map[string]struct{})
+	cg.components = make(map[string]map[string]struct{})
+	cg.clear()
+
+	if !cg.connected() {
+		return nil
+	}
+
+	if err := cg.destroy(ctx); err != nil {
+		return fmt.Errorf("unable to destroy change group: %w", err)
+	}
+
+	cg.closeIfEmpty()
+	return nil
+}
+
+func (cg *ChangeGroup) destroy(ctx context.Context) error {
+	cg.log.Info("Destroying change group")
+
+	_, err := cg.client.do(ctx, cg.conn, cg.newRequest("ChangeGroup.Destroy"))
+	return err
+}
+
+// set updates the value of a control that is already in the change group,
+// so that reads right after a set don't have to wait for the next poll.
+func (cg *ChangeGroup) set(component, name string, value interface{}) {
+	cg.valuesMu.Lock()
+	defer cg.valuesMu.Unlock()
+
+	key := controlKey{component: component, name: name}
+	val, ok := cg.values[key]
+	if !ok {
+		return
+	}
+
+	switch v := value.(type) {
+	case float64:
+		val.Value = v
+	case bool:
+		if v {
+			val.Value = 1
+		} else {
+			val.Value = 0
+		}
+	case string:
+		val.String = v
+	}
+
+	cg.values[key] = val
+}
+
+// setPosition updates the cached position of a control after it has been set.
+func (cg *ChangeGroup) setPosition(component, name string, position float64) {
+	cg.valuesMu.Lock()
+	defer cg.valuesMu.Unlock()
+
+	key := controlKey{component: component, name: name}
+	if val, ok := cg.values[key]; ok {
+		val.Position = position
+		cg.values[key] = val
+	}
+}
+
+// update stores changes sent by the core.
+func (cg *ChangeGroup) update(changes []QSCChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	cg.valuesMu.Lock()
+	for _, change := range changes {
+		cg.values[controlKey{component: change.Component, name: change.Name}] = change
+	}
+	cg.valuesMu.Unlock()
+
+	cg.log.Debug("Got changes", zap.Int("count", len(changes)))
+
+	if cg.onChange != nil {
+		cg.onChange(changes)
+	}
+}
+
+func (cg *ChangeGroup) clear() {
+	cg.valuesMu.Lock()
+	defer cg.valuesMu.Unlock()
+
+	cg.values = make(map[controlKey]QSCChange)
+}
+
+// closeIfEmpty closes the change group's connection once there is nothing left in the group,
+// so that the core stops auto polling it. The group is created on a new connection when controls are added again.
+// It returns true if the connection was closed.
+// cg.mu must be held.
+func (cg *ChangeGroup) closeIfEmpty() bool {
+	if len(cg.controls) > 0 || len(cg.components) > 0 || !cg.connected() {
+		return false
+	}
+
+	cg.log.Info("Closing change group connection, the group is empty")
+	cg.conn.close(errIdle)
+	return true
+}
+
+// connected returns true if the change group exists on an open connection.
+// cg.mu must be held.
+func (cg *ChangeGroup) connected() bool {
+	if cg.conn == nil {
+		return false
+	}
+
+	select {
+	case <-cg.conn.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// connection returns the connection the change group exists on. If the connection had to be reopened,
+// every control is added to the group again and fresh is true.
+// cg.mu must be held.
+func (cg *ChangeGroup) connection(ctx context.Context) (conn *clientConn, fresh bool, err error) {
+	conn, err = cg.client.connection(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if conn == cg.conn {
+		return conn, false, nil
+	}
+
+	if err := cg.setup(ctx, conn); err != nil {
+		conn.close(err)
+		return nil, false, err
+	}
+
+	cg.conn = conn
+	go cg.watch(conn)
+
+	return conn, true, nil
+}
+
+// setup creates the change group on conn with every control that should be in it.
+// cg.mu must be held.
+func (cg *ChangeGroup) setup(ctx context.Context, conn *clientConn) error {
+	cg.log.Info("Creating change group", zap.Int("controls", len(cg.controls)), zap.Int("components", len(cg.components)))
+
+	if len(cg.controls) > 0 {
+		req := cg.newRequest("ChangeGroup.AddControl")
+		for name := range cg.controls {
+			req.Params.Controls = append(req.Params.Controls, name)
+		}
+
+		if _, err := cg.client.do(ctx, conn, req); err != nil {
+			return fmt.Errorf("unable to add controls to change group: %w", err)
+		}
+	}
+
+	for component, controls := range cg.components {
+		req := cg.newRequest("ChangeGroup.AddComponentControl")
+		names := make([]string, 0, len(controls))
+		for name := range controls {
+			names = append(names, name)
+		}
+
+		req.Params.Component = componentParam(component, names)
+
+		if _, err := cg.client.do(ctx, conn, req); err != nil {
+			return fmt.Errorf("unable to add component controls to change group: %w", err)
+		}
+	}
+
+	// without auto poll, changes are only read by calling Poll
+	if cg.rate <= 0 {
+		return nil
+	}
+
+	return cg.autoPoll(ctx, conn)
+}
+
+func (cg *ChangeGroup) autoPoll(ctx context.Context, conn *clientConn) error {
+	req := cg.newRequest("ChangeGroup.AutoPoll")
+	req.Params.Rate = cg.rate.Seconds()
+
+	cg.log.Info("Starting change group auto poll", zap.Duration("rate", cg.rate))
+
+	_, err := cg.client.do(ctx, conn, req)
+	return err
+}
+
+// watch clears the stale values when conn closes, and then reconnects as long as there are controls in the group.
+func (cg *ChangeGroup) watch(conn *clientConn) {
+	<-conn.done
+
+	if !errors.Is(conn.err, errIdle) {
+		cg.log.Warn("change group connection closed", zap.Error(conn.err))
+	}
+
+	cg.mu.Lock()
+	if cg.conn == conn {
+		cg.clear()
+	}
+	cg.mu.Unlock()
+
+	for {
+		cg.mu.Lock()
+		if cg.conn != conn || (len(cg.controls) == 0 && len(cg.components) == 0) {
+			cg.mu.Unlock()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, _, err := cg.connection(ctx)
+		cancel()
+
+		cg.mu.Unlock()
+
+		if err == nil || errors.Is(err, errClientClosed) {
+			return
+		}
+
+		cg.log.Warn("unable to reconnect change group", zap.Error(err))
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func componentParam(component string, controls []string) *QSCChangeGroupComponentParam {
+	param := &QSCChangeGroupComponentParam{
+		Name: component,
+	}
+
+	for _, name := range controls {
+		param.Controls = append(param.Controls, QSCComponentControl{Name: name})
+	}
+
+	return param
+}
+
+// ChangeGroup returns the DSP's change group, which keeps the values of its controls up to date on a dedicated connection.
+func (d *DSP) ChangeGroup() *ChangeGroup {
+	return d.changes
+}
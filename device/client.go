@@ -0,0 +1,678 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// request is any jsonrpc request that can be sent to the core.
+type request interface {
+	requestID() int
+}
+
+// frame is the minimum we need to decode from every message the core sends
+// in order to route it. Frames without an id are notifications.
+type frame struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// notifyFunc is called with every notification the core sends.
+type notifyFunc func(method string, params json.RawMessage)
+
+// connectionFunc is called whenever a connection to the core is opened, fails to open, or fails.
+// err is nil when up is true.
+type connectionFunc func(up bool, err error)
+
+// errIdle closes connections that haven't been used for their ttl.
+var errIdle = errors.New("connection was idle")
+
+// errClosed is returned for requests that weren't sent because their connection had already closed.
+var errClosed = errors.New("connection is closed")
+
+// errClientClosed is returned once a client has been closed, and closes its connection.
+var errClientClosed = errors.New("client is closed")
+
+// _dialTimeout is how long opening a connection, including logging on, may take.
+const _dialTimeout = 10 * time.Second
+
+// client is a QRC client that multiplexes any number of outstanding requests
+// over a single connection to the core. Every request is given a unique id,
+// and a reader goroutine routes each response back to the caller waiting on that id.
+type client struct {
+	addr      string
+	ttl       time.Duration
+	delay     time.Duration
+	keepAlive time.Duration
+	log       *zap.Logger
+
+	// redial reopens the connection as soon as it fails, instead of waiting for the next request,
+	// as long as a request has been started within the ttl
+	redial bool
+
+	dialer DialFunc
+
+	credentials  CredentialsFunc
+	notify       notifyFunc
+	onConnection connectionFunc
+
+	id atomic.Int64
+
+	// lastUsed is when a request was last started, in unix nanoseconds
+	lastUsed atomic.Int64
+
+	mu      sync.Mutex
+	conn    *clientConn
+	dialing *dialCall
+	closed  bool
+}
+
+// dialCall is a connection being opened, which every request that needs a connection waits on.
+type dialCall struct {
+	done chan struct{}
+	conn *clientConn
+	err  error
+}
+
+// clientConn is a single connection to the core and the requests waiting on it.
+type clientConn struct {
+	Transport
+	ttl time.Duration
+
+	writeMu   sync.Mutex
+	lastWrite atomic.Int64
+
+	mu      sync.Mutex
+	pending map[int]chan []byte
+	idle    *time.Timer
+	err     error
+	done    chan struct{}
+
+	// untouched is how many of the pending requests don't keep the connection from being closed for being idle
+	untouched int
+}
+
+func newClient(addr string, options options, notify notifyFunc) *client {
+	return &client{
+		addr:      addr,
+		ttl:       options.ttl,
+		delay:     options.delay,
+		keepAlive: options.keepAlive,
+		log:       options.logger,
+		notify:    notify,
+
+		credentials: options.credentials,
+		dialer:      options.dialer(),
+	}
+}
+
+// nextID returns a request id that has not yet been used on this client.
+func (c *client) nextID() int {
+	return int(c.id.Add(1))
+}
+
+// pendingRequest is a request that has been written to the core and is waiting for its response.
+type pendingRequest struct {
+	c    *client
+	conn *clientConn
+	id   int
+	resp chan []byte
+
+	// touch is whether the request keeps the connection from being closed for being idle
+	touch bool
+}
+
+// Do sends req to the core and waits for the response with the matching id.
+// The returned bytes are the full response frame, without the trailing NUL.
+func (c *client) Do(ctx context.Context, req request) ([]byte, error) {
+	p, err := c.Start(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Wait(ctx)
+}
+
+// Start writes req to the core without waiting for its response, which must then be read with Wait.
+// Requests that are started one after another are written in the same order, so the core applies them in that order.
+func (c *client) Start(ctx context.Context, req request) (*pendingRequest, error) {
+	c.lastUsed.Store(time.Now().UnixNano())
+
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := c.start(ctx, conn, req, true)
+	if !errors.Is(err, errClosed) {
+		return p, err
+	}
+
+	// the connection closed before the request was written, e.g. for being idle, so it can be sent on a new one
+	c.log.Debug("Connection closed before the request was sent, retrying on a new connection", zap.Error(err))
+
+	conn, err = c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.start(ctx, conn, req, true)
+}
+
+func (c *client) do(ctx context.Context, conn *clientConn, req request) ([]byte, error) {
+	return c.send(ctx, conn, req, true)
+}
+
+// send sends req on conn and waits for its response.
+// Requests that don't touch the connection don't keep it from being closed for being idle.
+func (c *client) send(ctx context.Context, conn *clientConn, req request, touch bool) ([]byte, error) {
+	p, err := c.start(ctx, conn, req, touch)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Wait(ctx)
+}
+
+// start writes req on conn and registers for its response.
+func (c *client) start(ctx context.Context, conn *clientConn, req request, touch bool) (*pendingRequest, error) {
+	toSend, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	id := req.requestID()
+	resp, err := conn.register(id, touch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+
+	if err := conn.write(ctx, toSend, c.delay, touch); err != nil {
+		conn.unregister(id, touch)
+		conn.close(err)
+
+		// the write deadline is the deadline of ctx
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %w", ErrTimeout, err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+
+	c.log.Debug("Sent request", zap.Int("id", id))
+	return &pendingRequest{c: c, conn: conn, id: id, resp: resp, touch: touch}, nil
+}
+
+// Wait waits for the response to the request and returns it the same way as Do.
+func (p *pendingRequest) Wait(ctx context.Context) ([]byte, error) {
+	defer p.conn.unregister(p.id, p.touch)
+
+	c, conn, id := p.c, p.conn, p.id
+
+	select {
+	case buf := <-p.resp:
+		c.log.Debug("Got response", zap.Int("id", id), zap.ByteString("response", buf))
+
+		var base BaseResponse
+		if err := json.Unmarshal(buf, &base); err != nil {
+			return nil, fmt.Errorf("unable to parse response: %w", err)
+		}
+
+		if base.Error != nil {
+			return buf, base.Error
+		}
+
+		return buf, nil
+	case <-conn.done:
+		return nil, fmt.Errorf("%w: connection closed while waiting for response: %w", ErrConnection, conn.err)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: unable to do request: %w", ErrTimeout, ctx.Err())
+		}
+
+		return nil, fmt.Errorf("unable to do request: %w", ctx.Err())
+	}
+}
+
+// RemoteAddr returns the address of the core on the other side of the connection.
+func (c *client) RemoteAddr(ctx context.Context) (net.Addr, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.RemoteAddr(), nil
+}
+
+// connection returns the open connection to the core, opening a new one if necessary.
+// Only one connection is opened at a time; every caller waits for it until their ctx is done.
+func (c *client) connection(ctx context.Context) (*clientConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%w: %w", ErrConnection, errClientClosed)
+	}
+
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+			c.conn = nil
+		default:
+			conn := c.conn
+			c.mu.Unlock()
+			return conn, nil
+		}
+	}
+
+	call := c.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		c.dialing = call
+
+		// the connection is opened for every caller, so it isn't tied to the context of the first one
+		go c.open(call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.conn, call.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: waiting for a new connection: %w", ErrTimeout, ctx.Err())
+		}
+
+		return nil, fmt.Errorf("waiting for a new connection: %w", ctx.Err())
+	}
+}
+
+// open opens a new connection for call and makes it the client's connection.
+func (c *client) open(call *dialCall) {
+	c.log.Info("Opening new connection")
+
+	ctx, cancel := context.WithTimeout(context.Background(), _dialTimeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		err = fmt.Errorf("%w: failed to open new connection: %w", ErrConnection, err)
+		c.log.Warn(err.Error())
+		c.connectionChanged(false, err)
+	} else {
+		c.log.Info("Successfully opened new connection")
+		c.connectionChanged(true, nil)
+	}
+
+	c.mu.Lock()
+	if c.closed && conn != nil {
+		conn.close(errClientClosed)
+		conn, err = nil, fmt.Errorf("%w: %w", ErrConnection, errClientClosed)
+	}
+
+	c.conn = conn
+	c.dialing = nil
+	c.mu.Unlock()
+
+	call.conn, call.err = conn, err
+	close(call.done)
+}
+
+func (c *client) dial(ctx context.Context) (*clientConn, error) {
+	t, err := c.dialer(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first frame is sent by the core as soon as we connect
+	prompt, err := t.ReadFrame()
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("unable to read new connection prompt: %w", err)
+	}
+
+	conn := &clientConn{
+		Transport: t,
+		ttl:       c.ttl,
+		pending:   make(map[int]chan []byte),
+		done:      make(chan struct{}),
+	}
+	conn.lastWrite.Store(time.Now().UnixNano())
+
+	// a ttl of 0 keeps the connection open until it fails.
+	// Keepalives don't reset the idle timer, so they only keep the connection open until it hasn't been used for the ttl.
+	if c.ttl > 0 {
+		conn.idle = time.AfterFunc(c.ttl, func() {
+			conn.mu.Lock()
+			// keepalives that are waiting for a response don't keep the connection open
+			waiting := len(conn.pending) - conn.untouched
+			conn.mu.Unlock()
+
+			if waiting > 0 {
+				conn.idle.Reset(conn.ttl)
+				return
+			}
+
+			c.log.Info("Closing idle connection")
+			conn.close(errIdle)
+		})
+	}
+
+	if len(bytes.TrimSpace(prompt)) > 0 {
+		var f frame
+		if err := json.Unmarshal(prompt, &f); err != nil {
+			c.log.Warn("unable to parse new connection prompt", zap.ByteString("prompt", prompt), zap.Error(err))
+		} else {
+			c.handle(f)
+		}
+	}
+
+	go c.read(conn)
+
+	if c.keepAlive > 0 {
+		go c.keepConnAlive(conn)
+	}
+
+	if err := c.logon(ctx, conn); err != nil {
+		conn.close(err)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// logon authenticates conn with the credentials for this core, if there are any.
+func (c *client) logon(ctx context.Context, conn *clientConn) error {
+	if c.credentials == nil {
+		return nil
+	}
+
+	creds, err := c.credentials(ctx, c.addr)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to get credentials: %w", err)
+	case creds == nil:
+		return nil
+	}
+
+	c.log.Info("Logging on", zap.String("user", creds.Username))
+
+	req := QSCLogonRequest{
+		BaseRequest: BaseRequest{JSONRPC: "2.0", ID: c.nextID(), Method: "Logon"},
+		Params: QSCLogonParams{
+			User:     creds.Username,
+			Password: creds.PIN,
+		},
+	}
+
+	_, err = c.do(ctx, conn, req)
+	var qscErr *Error
+	switch {
+	case errors.As(err, &qscErr):
+		return fmt.Errorf("%w: logon failed: %w", ErrUnauthorized, qscErr)
+	case err != nil:
+		return fmt.Errorf("unable to logon: %w", err)
+	}
+
+	return nil
+}
+
+// read routes every frame read from conn to the request waiting for it until conn is closed.
+func (c *client) read(conn *clientConn) {
+	for {
+		buf, err := conn.ReadFrame()
+		if err != nil {
+			conn.close(fmt.Errorf("unable to read response: %w", err))
+
+			// conn.err is the reason the connection was closed first
+			if !errors.Is(conn.err, errIdle) && !errors.Is(conn.err, errClientClosed) {
+				c.log.Warn("unable to read from connection", zap.Error(err))
+				c.connectionChanged(false, conn.err)
+
+				if c.redial {
+					go c.reconnect(conn)
+				}
+			}
+
+			return
+		}
+
+		if len(bytes.TrimSpace(buf)) == 0 {
+			continue
+		}
+
+		var f frame
+		if err := json.Unmarshal(buf, &f); err != nil {
+			c.log.Warn("unable to parse frame", zap.ByteString("frame", buf), zap.Error(err))
+			continue
+		}
+
+		if f.ID == nil {
+			c.handle(f)
+			continue
+		}
+
+		conn.mu.Lock()
+		resp, ok := conn.pending[*f.ID]
+		conn.mu.Unlock()
+
+		if !ok {
+			c.log.Warn("no request waiting for response", zap.Int("id", *f.ID), zap.ByteString("response", buf))
+			continue
+		}
+
+		select {
+		case resp <- buf:
+		default:
+			c.log.Warn("duplicate response", zap.Int("id", *f.ID), zap.ByteString("response", buf))
+		}
+	}
+}
+
+// keepConnAlive sends NoOp on conn whenever nothing else has been sent for the keepalive interval,
+// so that the core doesn't close it, and closes it if the core doesn't answer.
+func (c *client) keepConnAlive(conn *clientConn) {
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, conn.lastWrite.Load())) < c.keepAlive {
+			continue
+		}
+
+		req := QSCNoOpRequest{
+			BaseRequest: BaseRequest{JSONRPC: "2.0", ID: c.nextID(), Method: "NoOp"},
+			Params:      struct{}{},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := c.send(ctx, conn, req, false)
+		cancel()
+
+		// an error from the core still means that the connection works
+		var qscErr *Error
+		if err != nil && !errors.As(err, &qscErr) {
+			c.log.Warn("keepalive failed, closing connection", zap.Error(err))
+			conn.close(fmt.Errorf("keepalive failed: %w", err))
+			return
+		}
+	}
+}
+
+// reconnect opens a new connection after conn fails, retrying every keepalive interval until it succeeds,
+// or until no request has been started for the ttl, after which the next request opens the connection.
+// Logon is sent again on the new connection if there are credentials.
+func (c *client) reconnect(conn *clientConn) {
+	retry := c.keepAlive
+	if retry <= 0 {
+		retry = 5 * time.Second
+	}
+
+	c.mu.Lock()
+	current := c.conn
+	c.mu.Unlock()
+
+	// the connection never finished opening, or it has already been replaced
+	if current != conn {
+		return
+	}
+
+	for {
+		if !c.wanted() {
+			c.log.Info("Not reconnecting, the connection hasn't been used recently")
+			return
+		}
+
+		c.log.Info("Reconnecting")
+
+		ctx, cancel := context.WithTimeout(context.Background(), _dialTimeout)
+		_, err := c.connection(ctx)
+		cancel()
+
+		switch {
+		case err == nil:
+			return
+		case errors.Is(err, ErrUnauthorized):
+			// trying again won't fix the credentials
+			return
+		case errors.Is(err, errClientClosed):
+			return
+		}
+
+		time.Sleep(retry)
+
+		c.mu.Lock()
+		current = c.conn
+		c.mu.Unlock()
+
+		// a request opened a new connection in the meantime
+		if current != nil {
+			return
+		}
+	}
+}
+
+// Close closes the connection to the core, and keeps new ones from being opened.
+func (c *client) Close() {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if conn != nil {
+		conn.close(errClientClosed)
+	}
+}
+
+// wanted is whether a request has been started within the ttl, so that a connection is still needed.
+// A ttl of 0 always wants a connection.
+func (c *client) wanted() bool {
+	return c.ttl <= 0 || time.Since(time.Unix(0, c.lastUsed.Load())) < c.ttl
+}
+
+func (c *client) connectionChanged(up bool, err error) {
+	if c.onConnection != nil {
+		c.onConnection(up, err)
+	}
+}
+
+// handle processes a notification sent by the core.
+func (c *client) handle(f frame) {
+	c.log.Debug("Got notification", zap.String("method", f.Method), zap.ByteString("params", f.Params))
+
+	if c.notify != nil {
+		c.notify(f.Method, f.Params)
+	}
+}
+
+func (cc *clientConn) register(id int, touch bool) (chan []byte, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.err != nil {
+		return nil, fmt.Errorf("%w: %w", errClosed, cc.err)
+	}
+
+	if _, ok := cc.pending[id]; ok {
+		return nil, fmt.Errorf("a request with id %d is already waiting for a response", id)
+	}
+
+	resp := make(chan []byte, 1)
+	cc.pending[id] = resp
+	if !touch {
+		cc.untouched++
+	}
+
+	return resp, nil
+}
+
+func (cc *clientConn) unregister(id int, touch bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if _, ok := cc.pending[id]; !ok {
+		return
+	}
+
+	delete(cc.pending, id)
+	if !touch {
+		cc.untouched--
+	}
+}
+
+func (cc *clientConn) write(ctx context.Context, buf []byte, delay time.Duration, touch bool) error {
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(3 * time.Second)
+	}
+
+	if err := cc.WriteFrame(buf, deadline); err != nil {
+		return fmt.Errorf("unable to write command: %w", err)
+	}
+
+	cc.lastWrite.Store(time.Now().UnixNano())
+	if touch && cc.idle != nil {
+		cc.idle.Reset(cc.ttl)
+	}
+
+	// give the core a break before the next command
+	time.Sleep(delay)
+	return nil
+}
+
+func (cc *clientConn) close(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.err != nil {
+		return
+	}
+
+	cc.err = err
+	if cc.idle != nil {
+		cc.idle.Stop()
+	}
+
+	cc.Transport.Close()
+	close(cc.done)
+}
@@ -0,0 +1,194 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QSCComponentGetRequest is for the Component.Get method
+type QSCComponentGetRequest struct {
+	BaseRequest
+	Params QSCComponentGetParams `json:"params"`
+}
+
+// QSCComponentGetParams is the parameters for the Component.Get method
+type QSCComponentGetParams struct {
+	Name     string
+	Controls []QSCComponentControl
+}
+
+// QSCComponentControl is a single control of a named component
+type QSCComponentControl struct {
+	Name string
+}
+
+// QSCComponentGetResponse is the response to the Component.Get method
+type QSCComponentGetResponse struct {
+	BaseResponse
+	Result QSCComponentGetResult `json:"result"`
+}
+
+// QSCComponentGetResult is the current state of the requested controls of a component
+type QSCComponentGetResult struct {
+	Name     string
+	Controls []QSCGetStatusResult
+}
+
+// QSCComponentSetRequest is for the Component.Set method
+type QSCComponentSetRequest struct {
+	BaseRequest
+	Params QSCComponentSetParams `json:"params"`
+}
+
+// QSCComponentSetParams is the parameters for the Component.Set method
+type QSCComponentSetParams struct {
+	Name     string
+	Controls []QSCSetStatusParams
+}
+
+func (d *DSP) GetGenericComponentGetRequest(ctx context.Context) QSCComponentGetRequest {
+	return QSCComponentGetRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Component.Get"}, Params: QSCComponentGetParams{}}
+}
+
+func (d *DSP) GetGenericComponentSetRequest(ctx context.Context) QSCComponentSetRequest {
+	return QSCComponentSetRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Component.Set"}, Params: QSCComponentSetParams{}}
+}
+
+func (dm *DeviceManager) HandlerGetComponent(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	component := ctx.Param("component")
+
+	var controls []string
+	for _, c := range ctx.QueryArray("controls") {
+		for _, name := range strings.Split(c, ",") {
+			if name != "" {
+				controls = append(controls, name)
+			}
+		}
+	}
+
+	if len(controls) == 0 {
+		writeError(ctx, fmt.Errorf("%w: at least one control must be requested", ErrInvalidParams))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("getting component controls", zap.String("address", addr), zap.String("component", component), zap.Strings("controls", controls))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	vals, err := dsp.Component(c, component, controls...)
+	if err != nil {
+		dm.Log.Error("unable to get component controls", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got component controls", zap.String("address", addr), zap.String("controls", fmt.Sprintf("%+v", vals)))
+	ctx.JSON(http.StatusOK, vals)
+}
+
+func (dm *DeviceManager) HandlerSetComponent(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	component := ctx.Param("component")
+
+	var values map[string]interface{}
+	if err := ctx.ShouldBindJSON(&values); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	if len(values) == 0 {
+		writeError(ctx, fmt.Errorf("%w: at least one control must be set", ErrInvalidParams))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("setting component controls", zap.String("address", addr), zap.String("component", component), zap.Any("values", values))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := dsp.SetComponent(c, component, values); err != nil {
+		dm.Log.Error("unable to set component controls", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	controls := make([]string, 0, len(values))
+	for name := range values {
+		controls = append(controls, name)
+	}
+
+	vals, err := dsp.Component(c, component, controls...)
+	if err != nil {
+		dm.Log.Error("unable to get component controls", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Set component controls", zap.String("address", addr), zap.String("component", component))
+	ctx.JSON(http.StatusOK, vals)
+}
+
+// Component gets the current state of controls on the named component.
+func (d *DSP) Component(ctx context.Context, component string, controls ...string) ([]QSCGetStatusResult, error) {
+	req := d.GetGenericComponentGetRequest(ctx)
+	req.Params.Name = component
+	for _, control := range controls {
+		req.Params.Controls = append(req.Params.Controls, QSCComponentControl{Name: control})
+	}
+
+	d.log.Info("Getting component controls", zap.String("component", component), zap.Strings("controls", controls))
+
+	resp, err := d.client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	qscResp := QSCComponentGetResponse{}
+	if err := json.Unmarshal(resp, &qscResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %w", err)
+	}
+
+	if qscResp.Result.Name != component {
+		return nil, fmt.Errorf("response name (%s) does not match the name sent (%s)", qscResp.Result.Name, component)
+	}
+
+	return qscResp.Result.Controls, nil
+}
+
+// SetComponent sets the value of controls on the named component.
+// values is a map of control name to the value it should be set to, which must be a number, string or boolean.
+func (d *DSP) SetComponent(ctx context.Context, component string, values map[string]interface{}) error {
+	req := d.GetGenericComponentSetRequest(ctx)
+	req.Params.Name = component
+	for name, value := range values {
+		value, err := normalizeValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		req.Params.Controls = append(req.Params.Controls, QSCSetStatusParams{Name: name, Value: value})
+	}
+
+	d.log.Info("Setting component controls", zap.String("component", component), zap.Any("values", values))
+
+	if _, err := d.client.Do(ctx, req); err != nil {
+		return err
+	}
+
+	for _, control := range req.Params.Controls {
+		d.changes.set(component, control.Name, control.Value)
+	}
+
+	return nil
+}
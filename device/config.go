@@ -0,0 +1,201 @@
+package device
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// Config is the configuration for the DSPs a DeviceManager controls.
+type Config struct {
+	// DSPs holds the configuration for each DSP, keyed by address.
+	DSPs map[string]DSPConfig `json:"dsps"`
+}
+
+// Validate checks that every DSP's configuration can be used, so that mistakes are found when the configuration
+// is loaded instead of when a DSP is first used.
+func (c Config) Validate() error {
+	for addr, config := range c.DSPs {
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("invalid config for %s: %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// DSPConfig is the configuration for the DSP at a single address.
+type DSPConfig struct {
+	// Username and PIN are used to log on to cores that have access control enabled.
+	Username string `json:"username"`
+	PIN      string `json:"pin"`
+
+	// Protocol is how we talk to the core, one of "qrc", "ecp", "ws", or "wss". The default is "qrc".
+	Protocol Protocol `json:"protocol"`
+
+	// Backup is the address of the other core in a redundant pair.
+	// Commands are only sent to whichever of the two cores is Active, and requests for either address go to the same DSP.
+	Backup string `json:"backup"`
+
+	// TLS is how the core is verified when Protocol is "wss".
+	TLS *TLSConfig `json:"tls"`
+
+	// Curve is the volume curve for every block that doesn't have its own.
+	Curve *CurveConfig `json:"curve"`
+
+	// Blocks holds the configuration for each volume block, keyed by the name of its gain control.
+	Blocks map[string]BlockConfig `json:"blocks"`
+}
+
+// Validate checks that the configuration of a DSP can be used.
+func (c DSPConfig) Validate() error {
+	if c.TLS != nil {
+		if _, err := c.TLS.Config(); err != nil {
+			return fmt.Errorf("invalid tls config: %w", err)
+		}
+	}
+
+	if c.Curve != nil {
+		if _, err := c.Curve.Curve(); err != nil {
+			return fmt.Errorf("invalid volume curve: %w", err)
+		}
+	}
+
+	for block, config := range c.Blocks {
+		if config.Curve == nil {
+			continue
+		}
+
+		if _, err := config.Curve.Curve(); err != nil {
+			return fmt.Errorf("invalid volume curve for %s: %w", block, err)
+		}
+	}
+
+	return nil
+}
+
+// BlockConfig is the configuration for a single volume block.
+type BlockConfig struct {
+	// VolumeMode is how volume levels are sent to the block, either "gain" or "position".
+	VolumeMode VolumeMode `json:"volumeMode"`
+
+	// Curve is how volume levels are converted to gain for the block.
+	Curve *CurveConfig `json:"curve"`
+}
+
+// TLSConfig is how a core's certificate is verified.
+type TLSConfig struct {
+	// CAFile is a PEM file of certificate authorities to trust, in addition to the system's.
+	CAFile string `json:"caFile"`
+
+	// InsecureSkipVerify accepts any certificate, such as the self-signed one cores ship with.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// Config returns the tls.Config that verifies cores the way c describes.
+func (c TLSConfig) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("ca file does not contain any certificates")
+	}
+
+	config.RootCAs = pool
+	return config, nil
+}
+
+// primary returns the address of the DSP that addr is configured as the backup of.
+// Addresses that have their own configuration are never a backup.
+func (c Config) primary(addr string) (string, bool) {
+	if _, ok := c.DSPs[addr]; ok {
+		return "", false
+	}
+
+	for primary, config := range c.DSPs {
+		if config.Backup == addr && primary != addr {
+			return primary, true
+		}
+	}
+
+	return "", false
+}
+
+// dspOptions returns the options for the DSP at addr based on its configuration.
+func (dm *DeviceManager) dspOptions(addr string) []Option {
+	var opts []Option
+
+	config, ok := dm.Config.DSPs[addr]
+	if !ok {
+		return opts
+	}
+
+	if config.Username != "" || config.PIN != "" {
+		opts = append(opts, WithCredentials(StaticCredentials(config.Username, config.PIN)))
+	}
+
+	if config.Protocol != "" {
+		opts = append(opts, WithProtocol(config.Protocol))
+	}
+
+	if config.Backup != "" {
+		opts = append(opts, WithBackup(config.Backup))
+	}
+
+	// the configuration is validated when it is loaded, so this only fails if the ca file changed since then
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.Config()
+		if err != nil {
+			dm.Log.Warn("ignoring invalid tls config", zap.String("address", addr), zap.Error(err))
+		} else {
+			opts = append(opts, WithTLSConfig(tlsConfig))
+		}
+	}
+
+	if config.Curve != nil {
+		opts = append(opts, dm.curveOption(addr, "", *config.Curve)...)
+	}
+
+	for block, blockConfig := range config.Blocks {
+		if blockConfig.VolumeMode != "" {
+			opts = append(opts, WithVolumeMode(block, blockConfig.VolumeMode))
+		}
+
+		if blockConfig.Curve != nil {
+			opts = append(opts, dm.curveOption(addr, block, *blockConfig.Curve)...)
+		}
+	}
+
+	return opts
+}
+
+// curveOption returns the option for a configured curve. Curves are checked by Config.Validate,
+// so an invalid one is only ignored for configurations that weren't validated.
+func (dm *DeviceManager) curveOption(addr, block string, config CurveConfig) []Option {
+	curve, err := config.Curve()
+	if err != nil {
+		dm.Log.Warn("ignoring invalid volume curve", zap.String("address", addr), zap.String("block", block), zap.Error(err))
+		return nil
+	}
+
+	return []Option{WithVolumeCurve(block, curve)}
+}
@@ -0,0 +1,23 @@
+package device
+
+import "context"
+
+// Credentials are used to log on to cores that have access control enabled.
+type Credentials struct {
+	Username string `json:"username"`
+	PIN      string `json:"pin"`
+}
+
+// CredentialsFunc returns the credentials for the core at addr.
+// If it returns nil credentials, the connection is not logged on.
+type CredentialsFunc func(ctx context.Context, addr string) (*Credentials, error)
+
+// StaticCredentials returns a CredentialsFunc that always returns the same username and PIN.
+func StaticCredentials(username, pin string) CredentialsFunc {
+	return func(context.Context, string) (*Credentials, error) {
+		return &Credentials{
+			Username: username,
+			PIN:      pin,
+		}, nil
+	}
+}
@@ -0,0 +1,196 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// VolumeCurve converts between a volume level from 0 to 100 and a gain in dB.
+type VolumeCurve interface {
+	// Gain returns the gain in dB for a volume level.
+	Gain(level int) float64
+
+	// Level returns the volume level for a gain in dB.
+	Level(gain float64) int
+}
+
+// DefaultVolumeCurve is the curve used for blocks that don't have one configured.
+// It is the mapping this service has always used: a level is 20·log10 of level/100 dB, and volume 0 is -100 dB.
+// Levels read back are not capped, so a block above 0 dB reads as more than 100.
+var DefaultVolumeCurve VolumeCurve = defaultCurve{}
+
+type defaultCurve struct{}
+
+func (defaultCurve) Gain(level int) float64 {
+	if level <= 0 {
+		return -100
+	}
+
+	return 20 * math.Log10(float64(level)/100)
+}
+
+func (defaultCurve) Level(gain float64) int {
+	return int(math.Round(math.Pow(10, gain/20) * 100))
+}
+
+// LogCurve follows 20·log10 of the volume level, so that each halving of the level is about 6 dB quieter.
+// Volume 100 is MaxDB, and volume 0 (and anything the curve would put below MinDB) is MinDB.
+type LogCurve struct {
+	MinDB float64
+	MaxDB float64
+}
+
+func (c LogCurve) Gain(level int) float64 {
+	if level <= 0 {
+		return c.MinDB
+	}
+
+	gain := 20*math.Log10(float64(clampLevel(level))/100) + c.MaxDB
+	return math.Max(gain, c.MinDB)
+}
+
+func (c LogCurve) Level(gain float64) int {
+	if gain <= c.MinDB {
+		return 0
+	}
+
+	return clampLevel(int(math.Round(math.Pow(10, (gain-c.MaxDB)/20) * 100)))
+}
+
+// LinearCurve spreads the volume levels evenly in dB between MinDB at volume 0 and MaxDB at volume 100.
+type LinearCurve struct {
+	MinDB float64
+	MaxDB float64
+}
+
+func (c LinearCurve) Gain(level int) float64 {
+	return c.MinDB + float64(clampLevel(level))/100*(c.MaxDB-c.MinDB)
+}
+
+func (c LinearCurve) Level(gain float64) int {
+	if c.MaxDB == c.MinDB {
+		return 0
+	}
+
+	return clampLevel(int(math.Round((gain - c.MinDB) / (c.MaxDB - c.MinDB) * 100)))
+}
+
+// CurvePoint is a single volume level and the gain it maps to.
+type CurvePoint struct {
+	Level int     `json:"level"`
+	Gain  float64 `json:"db"`
+}
+
+// TableCurve interpolates linearly between a table of points.
+// Levels and gains outside of the table are clamped to its first and last points.
+type TableCurve struct {
+	points []CurvePoint
+}
+
+// NewTableCurve creates a curve from points, which can be in any order.
+// There must be at least two points, with levels from 0 to 100, and the gain must go up as the level goes up.
+func NewTableCurve(points []CurvePoint) (*TableCurve, error) {
+	if len(points) < 2 {
+		return nil, errors.New("a table curve needs at least two points")
+	}
+
+	sorted := make([]CurvePoint, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Level < sorted[j].Level
+	})
+
+	for i, point := range sorted {
+		if point.Level < 0 || point.Level > 100 {
+			return nil, fmt.Errorf("level %d must be between 0 and 100", point.Level)
+		}
+
+		if i > 0 && (point.Level == sorted[i-1].Level || point.Gain <= sorted[i-1].Gain) {
+			return nil, fmt.Errorf("the gain at level %d must be higher than the gain at level %d", point.Level, sorted[i-1].Level)
+		}
+	}
+
+	return &TableCurve{points: sorted}, nil
+}
+
+func (c *TableCurve) Gain(level int) float64 {
+	first, last := c.points[0], c.points[len(c.points)-1]
+	switch {
+	case level <= first.Level:
+		return first.Gain
+	case level >= last.Level:
+		return last.Gain
+	}
+
+	i := sort.Search(len(c.points), func(i int) bool {
+		return c.points[i].Level >= level
+	})
+
+	lo, hi := c.points[i-1], c.points[i]
+	return lo.Gain + float64(level-lo.Level)/float64(hi.Level-lo.Level)*(hi.Gain-lo.Gain)
+}
+
+func (c *TableCurve) Level(gain float64) int {
+	first, last := c.points[0], c.points[len(c.points)-1]
+	switch {
+	case gain <= first.Gain:
+		return first.Level
+	case gain >= last.Gain:
+		return last.Level
+	}
+
+	i := sort.Search(len(c.points), func(i int) bool {
+		return c.points[i].Gain >= gain
+	})
+
+	lo, hi := c.points[i-1], c.points[i]
+	return lo.Level + int(math.Round((gain-lo.Gain)/(hi.Gain-lo.Gain)*float64(hi.Level-lo.Level)))
+}
+
+func clampLevel(level int) int {
+	switch {
+	case level < 0:
+		return 0
+	case level > 100:
+		return 100
+	default:
+		return level
+	}
+}
+
+// CurveConfig is the configuration of a volume curve.
+type CurveConfig struct {
+	// Type is "log", "linear", or "table"
+	Type string `json:"type"`
+
+	// MinDB and MaxDB are the range of log and linear curves
+	MinDB float64 `json:"minDb"`
+	MaxDB float64 `json:"maxDb"`
+
+	// Points are the points of a table curve
+	Points []CurvePoint `json:"points"`
+}
+
+// Curve creates the volume curve described by the configuration.
+func (c CurveConfig) Curve() (VolumeCurve, error) {
+	switch c.Type {
+	case "log":
+		if c.MinDB >= c.MaxDB {
+			return nil, errors.New("minDb must be lower than maxDb")
+		}
+
+		return LogCurve{MinDB: c.MinDB, MaxDB: c.MaxDB}, nil
+	case "linear":
+		if c.MinDB >= c.MaxDB {
+			return nil, errors.New("minDb must be lower than maxDb")
+		}
+
+		return LinearCurve{MinDB: c.MinDB, MaxDB: c.MaxDB}, nil
+	case "table":
+		return NewTableCurve(c.Points)
+	default:
+		return nil, fmt.Errorf("unknown curve type %q", c.Type)
+	}
+}
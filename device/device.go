@@ -1,15 +1,15 @@
 package device
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/byuoitav/connpool"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -18,6 +18,7 @@ type DeviceManager struct {
 	Log      *zap.Logger
 	LogLevel *zap.AtomicLevel
 	DspList  *sync.Map
+	Config   Config
 }
 
 func (dm *DeviceManager) RunHTTPServer(router *gin.Engine, port string) error {
@@ -29,9 +30,42 @@ func (dm *DeviceManager) RunHTTPServer(router *gin.Engine, port string) error {
 	dev.GET("/:address/:name/mute/status", dm.HandlerMuteStatus)
 	dev.GET("/:address/:name/volume/set/:level", dm.HandlerSetVolume)
 	dev.GET("/:address/:name/volume/level", dm.HandlerGetVolume)
+	dev.GET("/:address/:name/volume/fade/:level", dm.HandlerFadeVolume)
+	dev.GET("/:address/:name/volume/step/:delta", dm.HandlerStepVolume)
+	dev.GET("/:address/:name/mute/toggle", dm.HandlerToggleMute)
+	dev.GET("/:address/volumes", dm.HandlerGetVolumes)
+	dev.POST("/:address/controls", dm.HandlerSetControls)
 	dev.PUT("/:address/generic/:name/:value", dm.HandlerSetGeneric)
+	dev.PUT("/:address/generic/:name", dm.HandlerSetGenericJSON)
 	dev.GET("/:address/generic/:name", dm.HandlerGetGeneric)
 	dev.GET("/:address/hardware", dm.HandlerGetInfo)
+	dev.GET("/:address/engine", dm.HandlerGetEngineStatus)
+	dev.GET("/:address/component/:component", dm.HandlerGetComponent)
+	dev.PUT("/:address/component/:component", dm.HandlerSetComponent)
+	dev.GET("/:address/components", dm.HandlerGetComponents)
+	dev.GET("/:address/components/:name/controls", dm.HandlerGetComponentControls)
+	dev.GET("/:address/mixer/:name/matrix", dm.HandlerGetMixerMatrix)
+	dev.PUT("/:address/mixer/:name/crosspoints/gain", dm.HandlerSetCrossPointGain)
+	dev.PUT("/:address/mixer/:name/crosspoints/mute", dm.HandlerSetCrossPointMute)
+	dev.PUT("/:address/mixer/:name/crosspoints/delay", dm.HandlerSetCrossPointDelay)
+	dev.PUT("/:address/mixer/:name/crosspoints/solo", dm.HandlerSetCrossPointSolo)
+	dev.PUT("/:address/mixer/:name/inputs/gain", dm.HandlerSetInputGain)
+	dev.PUT("/:address/mixer/:name/inputs/mute", dm.HandlerSetInputMute)
+	dev.PUT("/:address/mixer/:name/inputs/solo", dm.HandlerSetInputSolo)
+	dev.PUT("/:address/mixer/:name/outputs/gain", dm.HandlerSetOutputGain)
+	dev.PUT("/:address/mixer/:name/outputs/mute", dm.HandlerSetOutputMute)
+	dev.PUT("/:address/mixer/:name/cues/gain", dm.HandlerSetCueGain)
+	dev.PUT("/:address/mixer/:name/cues/mute", dm.HandlerSetCueMute)
+	dev.PUT("/:address/mixer/:name/cues/inputs/enable", dm.HandlerSetInputCueEnable)
+	dev.PUT("/:address/mixer/:name/cues/inputs/afl", dm.HandlerSetInputCueAfl)
+	dev.POST("/:address/snapshot/:bank/:number/load", dm.HandlerLoadSnapshot)
+	dev.POST("/:address/snapshot/:bank/:number/save", dm.HandlerSaveSnapshot)
+	dev.POST("/:address/player/:name/start", dm.HandlerStartLoopPlayer)
+	dev.POST("/:address/player/:name/stop", dm.HandlerStopLoopPlayer)
+	dev.POST("/:address/player/:name/cancel", dm.HandlerCancelLoopPlayer)
+	dev.GET("/:address/player/:name/status", dm.HandlerGetPlayerStatus)
+	dev.GET("/:address/subscribe", dm.HandlerSubscribe)
+	dev.GET("/:address/events", dm.HandlerEvents)
 
 	server := &http.Server{
 		Addr:           port,
@@ -47,24 +81,73 @@ func (dm *DeviceManager) CreateDSP(addr string) *DSP {
 		return dsp.(*DSP)
 	}
 
-	dsp := newDSP(addr)
+	// the backup of a redundant pair is controlled through the same DSP as its primary
+	if primary, ok := dm.Config.primary(addr); ok {
+		dsp, _ := dm.DspList.LoadOrStore(addr, dm.CreateDSP(primary))
+		return dsp.(*DSP)
+	}
 
-	dm.DspList.Store(addr, dsp)
-	return dsp
+	// a DSP doesn't open any connections until it is used,
+	// so the ones that lose a race to be stored are never used and cost nothing
+	dsp, _ := dm.DspList.LoadOrStore(addr, newDSP(addr, dm.dspOptions(addr)...))
+	return dsp.(*DSP)
+}
+
+// Close closes every DSP the manager has created. DSPs created after it is called aren't closed.
+func (dm *DeviceManager) Close() {
+	closed := make(map[*DSP]bool)
+	dm.DspList.Range(func(addr, dsp interface{}) bool {
+		dm.DspList.Delete(addr)
+
+		// the backup of a redundant pair shares its primary's DSP
+		if d := dsp.(*DSP); !closed[d] {
+			closed[d] = true
+			d.Close()
+		}
+
+		return true
+	})
 }
 
 type DSP struct {
-	pool *connpool.Pool
-	log  *zap.Logger
+	client   *client
+	changes  *ChangeGroup
+	subs     *subscriptions
+	pollRate time.Duration
+	log      *zap.Logger
+
+	// watchTTL is how long a control that was read stays in the change group without being read again
+	watchTTL time.Duration
+
+	volumeModes map[string]VolumeMode
+	curves      map[string]VolumeCurve
+
+	// pair is the redundant pair this DSP belongs to, or nil if it is a single core
+	pair *redundantPair
+
+	// locks serializes steps and toggles of each control
+	locks *controlLocks
+
+	// mixers is the layout of each mixer that has been read, so that later reads only ask for its matrix controls
+	mixersMu sync.Mutex
+	mixers   map[string]mixerLayout
+
+	engineMu sync.RWMutex
+	engine   *QSCStatusGetResult
+
+	events    *eventBuffer
+	connMu    sync.Mutex
+	connected *bool
 }
 
 const _kTimeoutInSeconds = 2.0
 
 func newDSP(addr string, opts ...Option) *DSP {
 	options := options{
-		ttl:    30 * time.Second,
-		delay:  500 * time.Millisecond,
-		logger: zap.NewNop(),
+		ttl:       30 * time.Second,
+		keepAlive: 20 * time.Second,
+		logger:    zap.NewNop(),
+		pollRate:  250 * time.Millisecond,
 	}
 
 	for _, o := range opts {
@@ -72,44 +155,46 @@ func newDSP(addr string, opts ...Option) *DSP {
 	}
 
 	d := &DSP{
-		pool: &connpool.Pool{
-			TTL:    options.ttl,
-			Delay:  options.delay,
-			Logger: options.logger.Sugar(),
-		},
-		log: options.logger,
+		subs:     newSubscriptions(),
+		locks:    newControlLocks(),
+		mixers:   make(map[string]mixerLayout),
+		events:   newEventBuffer(),
+		pollRate: options.pollRate,
+		watchTTL: options.ttl,
+		log:      options.logger,
+
+		volumeModes: options.volumeModes,
+		curves:      options.curves,
 	}
 
-	d.pool.NewConnection = func(ctx context.Context) (net.Conn, error) {
-		dial := net.Dialer{}
-		conn, err := dial.DialContext(ctx, "tcp", addr+":1710")
-		if err != nil {
-			return nil, err
-		}
+	// both clients follow the active core of a redundant pair
+	if options.backup != "" {
+		d.pair = newRedundantPair(addr, options.backup, options.dialer(), options.logger)
+		options.dial = d.pair.dial
+	}
 
-		deadline, ok := ctx.Deadline()
-		if !ok {
-			deadline = time.Now().Add(5 * time.Second)
-		}
+	d.client = newClient(addr, options, d.handleNotification)
+	d.changes = newChangeGroup(addr, options, d.handleNotification, d.publish)
 
-		conn.SetDeadline(deadline)
+	// the change group reconnects on its own, and only while it has controls
+	d.client.redial = options.keepAlive > 0
 
-		buf := make([]byte, 64)
-		for i := range buf {
-			buf[i] = 0x01
-		}
-		for !bytes.Contains(buf, []byte{0x00}) {
-			_, err := conn.Read(buf)
-			if err != nil {
-				conn.Close()
-				return nil, fmt.Errorf("unable to read new connection prompt: %w", err)
-			}
-		}
+	// connection events are about the connection commands are sent on;
+	// the change group's connection comes and goes with its controls, and reconnects on its own
+	d.client.onConnection = d.connectionChanged
+	return d
+}
 
-		return conn, nil
+// Close stops probing the other core of a redundant pair, stops unwatching controls, and closes every connection to the core.
+// The DSP can't be used once it has been closed.
+func (d *DSP) Close() {
+	if d.pair != nil {
+		d.pair.Close()
 	}
 
-	return d
+	d.subs.close()
+	d.client.Close()
+	d.changes.client.Close()
 }
 
 // BaseRequest are the common parts of every qsc jsonrpc request
@@ -119,25 +204,25 @@ type BaseRequest struct {
 	Method  string `json:"method"`
 }
 
+func (b BaseRequest) requestID() int {
+	return b.ID
+}
+
+// BaseResponse are the common parts of every qsc jsonrpc response
+type BaseResponse struct {
+	BaseRequest
+	Error *Error `json:"error"`
+}
+
+// QSCStatusReport is the EngineStatus notification the core sends when we connect and whenever its state changes
 type QSCStatusReport struct {
-	JSONRPC string `json:"jsonrpc"`
-	Method  string `json:"method"`
-	Params  struct {
-		Platform    string `json:"Platform"`
-		State       string `json:"State"`
-		DesignName  string `json:"DesignName"`
-		DesignCode  string `json:"DesignCode"`
-		IsRedundant bool   `json:"IsRedundant"`
-		IsEmulator  bool   `json:"IsEmulator"`
-		Status      struct {
-			Code   int    `json:"Code"`
-			String string `json:"String"`
-		} `json:"Status"`
-	} `json:"params"`
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  QSCStatusGetResult `json:"params"`
 }
 
 type QSCGetStatusResponse struct {
-	BaseRequest
+	BaseResponse
 	Result []QSCGetStatusResult `json:"result"`
 }
 type QSCGetStatusResult struct {
@@ -145,6 +230,24 @@ type QSCGetStatusResult struct {
 	Value    float64
 	String   string
 	Position float64
+
+	// text is true if the core sent the value as a string
+	text bool
+}
+
+// UnmarshalJSON accepts the string and boolean values the core sends for text and toggle controls.
+func (r *QSCGetStatusResult) UnmarshalJSON(data []byte) error {
+	type result QSCGetStatusResult
+	return unmarshalControl(data, (*result)(r), &r.Value, &r.String, &r.text)
+}
+
+// value is the String of a text control, whose value isn't a number, or the Value of any other control.
+func (r QSCGetStatusResult) value() interface{} {
+	if r.text {
+		return r.String
+	}
+
+	return r.Value
 }
 
 // QSCStatusGetResponse is the values that we are getting back from the StatusGet method
@@ -173,15 +276,41 @@ type QSCSetStatusRequest struct {
 
 // QSCSetStatusParams is the parameters for the Control.Set method
 type QSCSetStatusParams struct {
-	Name  string
-	Value float64
+	Name string
+
+	// Value is a float64, string or bool
+	Value interface{} `json:",omitempty"`
+
+	// Position is sent instead of Value to move the control along its own taper, from 0 to 1
+	Position *float64 `json:",omitempty"`
+
+	// Ramp is how many seconds the core takes to move the control to Value
+	Ramp float64 `json:",omitempty"`
 }
 
 type QSCSetStatusResponse struct {
-	BaseRequest
+	BaseResponse
 	Result QSCGetStatusResult `json:"result"`
 }
 
+// QSCNoOpRequest is for the NoOp method, which the core answers without doing anything
+type QSCNoOpRequest struct {
+	BaseRequest
+	Params struct{} `json:"params"`
+}
+
+// QSCLogonRequest is for the Logon method
+type QSCLogonRequest struct {
+	BaseRequest
+	Params QSCLogonParams `json:"params"`
+}
+
+// QSCLogonParams is the parameters for the Logon method
+type QSCLogonParams struct {
+	User     string
+	Password string
+}
+
 // QSCStatusGetRequest is for the StatusGet method
 type QSCStatusGetRequest struct {
 	BaseRequest
@@ -190,19 +319,123 @@ type QSCStatusGetRequest struct {
 
 // QSCStatusGetResponse gets the JSON response after calling the StatusGet method
 type QSCStatusGetResponse struct {
-	BaseRequest
+	BaseResponse
 	Result QSCStatusGetResult `json:"result"`
 }
 
 func (d *DSP) GetGenericSetStatusRequest(ctx context.Context) QSCSetStatusRequest {
-	return QSCSetStatusRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: 1, Method: "Control.Set"}, Params: QSCSetStatusParams{}}
+	return QSCSetStatusRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Control.Set"}, Params: QSCSetStatusParams{}}
 }
 
 func (d *DSP) GetGenericGetStatusRequest(ctx context.Context) QSCGetStatusRequest {
-	return QSCGetStatusRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: 1, Method: "Control.Get"}, Params: []string{}}
+	return QSCGetStatusRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Control.Get"}, Params: []string{}}
 }
 
 // GetGenericStatusGetRequest is used for retreiving EngineStatus and other information about the QSC
 func (d *DSP) GetGenericStatusGetRequest(ctx context.Context) QSCStatusGetRequest {
-	return QSCStatusGetRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: 1, Method: "StatusGet"}, Params: 0}
+	return QSCStatusGetRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "StatusGet"}, Params: 0}
+}
+
+// unmarshalControl decodes a control into v, a pointer to the control as a type without an UnmarshalJSON method,
+// and its Value into value, str and text with unmarshalValue.
+func unmarshalControl(data []byte, v interface{}, value *float64, str *string, text *bool) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	// the Value may not be a number, so every other field is decoded without it
+	raw := fields["Value"]
+	delete(fields, "Value")
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(rest, v); err != nil {
+		return err
+	}
+
+	return unmarshalValue(raw, value, str, text)
+}
+
+// unmarshalValue reads the Value of a control, which the core sends as a number for most controls,
+// a string for text controls, and a boolean for some toggles.
+// A string value is also put in str if the core did not send a String, and sets text if it isn't nil.
+// Any other value is an error, so that a control isn't silently read as 0 when the core sends something unexpected.
+func unmarshalValue(raw json.RawMessage, value *float64, str *string, text *bool) error {
+	var v interface{}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*value = v
+	case bool:
+		if v {
+			*value = 1
+		} else {
+			*value = 0
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			*value = f
+		}
+
+		if *str == "" {
+			*str = v
+		}
+
+		if text != nil {
+			*text = true
+		}
+	case nil:
+		// a control without a value, such as a trigger
+	default:
+		return fmt.Errorf("unexpected value %s", raw)
+	}
+
+	return nil
+}
+
+// normalizeValue checks that value can be sent to a control, and turns every kind of number into a float64.
+func normalizeValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool:
+		return v, nil
+	case float64:
+		return finite(v)
+	case float32:
+		return finite(float64(v))
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidParams, err)
+		}
+
+		return finite(f)
+	case nil:
+		return nil, fmt.Errorf("%w: a value is required", ErrInvalidParams)
+	default:
+		return nil, fmt.Errorf("%w: unsupported value type %T", ErrInvalidParams, value)
+	}
+}
+
+// finite returns f, or an error if it is NaN or infinite, which can't be sent as JSON.
+func finite(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%w: %v is not a finite number", ErrInvalidParams, f)
+	}
+
+	return f, nil
 }
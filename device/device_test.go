@@ -0,0 +1,2096 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestControl(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", func(req fakeRequest) interface{} {
+		return []map[string]interface{}{{"Name": "Source", "Value": 2, "String": "HDMI 2", "Position": 0.5}}
+	})
+
+	d := newFakeDSP(core)
+	res, err := d.Control(testContext(t), "Source")
+	if err != nil {
+		t.Fatalf("unable to get control: %v", err)
+	}
+
+	want := QSCGetStatusResult{Name: "Source", Value: 2, String: "HDMI 2", Position: 0.5}
+	if res != want {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+
+	if res.value() != 2.0 {
+		t.Errorf("got value %v, want 2", res.value())
+	}
+
+	reqs := core.Requests("Control.Get")
+	if len(reqs) != 1 || string(reqs[0].Params) != `["Source"]` {
+		t.Errorf("got requests %+v, want a single Control.Get of Source", reqs)
+	}
+}
+
+func TestControlStringValue(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", func(req fakeRequest) interface{} {
+		return []map[string]interface{}{{"Name": "Label", "Value": "Lobby"}}
+	})
+
+	d := newFakeDSP(core)
+	res, err := d.Control(testContext(t), "Label")
+	if err != nil {
+		t.Fatalf("unable to get control: %v", err)
+	}
+
+	if res.String != "Lobby" {
+		t.Errorf("got string %q, want %q", res.String, "Lobby")
+	}
+
+	if res.value() != "Lobby" {
+		t.Errorf("got value %v, want %q", res.value(), "Lobby")
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler fakeHandler
+		want    error
+		status  int
+	}{
+		{
+			name:    "unknown control",
+			handler: func(req fakeRequest) interface{} { return &Error{Code: 8, Message: "Unknown control"} },
+			want:    ErrInvalidControl,
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "missing from result",
+			handler: controlValues(map[string]float64{"Other": 1}),
+			want:    ErrInvalidControl,
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "logon required",
+			handler: func(req fakeRequest) interface{} { return &Error{Code: 10, Message: "Logon required"} },
+			want:    ErrUnauthorized,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "unsupported",
+			handler: func(req fakeRequest) interface{} { return &Error{Code: -32601, Message: "Method not found"} },
+			want:    ErrUnsupported,
+			status:  http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := newFakeCore(t)
+			core.Handle("Control.Get", tt.handler)
+
+			d := newFakeDSP(core)
+			_, err := d.Control(testContext(t), "Gain")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+
+			if status := httpStatus(err); status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestSetControl(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "number", value: 2, want: 2.0},
+		{name: "bool", value: true, want: true},
+		{name: "string", value: "HDMI 2", want: "HDMI 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core := newFakeCore(t)
+			core.Handle("Control.Set", echoSet)
+
+			d := newFakeDSP(core)
+			res, err := d.SetControl(testContext(t), "Source", tt.value)
+			if err != nil {
+				t.Fatalf("unable to set control: %v", err)
+			}
+
+			if res.Name != "Source" {
+				t.Errorf("got name %q, want %q", res.Name, "Source")
+			}
+
+			params := core.setParams()
+			if len(params) != 1 {
+				t.Fatalf("got %d sets, want 1", len(params))
+			}
+
+			if params[0].Name != "Source" || params[0].Value != tt.want {
+				t.Errorf("sent %+v, want Source set to %v", params[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestSetControlErrors(t *testing.T) {
+	t.Run("nil value", func(t *testing.T) {
+		core := newFakeCore(t)
+		d := newFakeDSP(core)
+
+		_, err := d.SetControl(testContext(t), "Source", nil)
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidParams)
+		}
+
+		if reqs := core.Requests("Control.Set"); len(reqs) != 0 {
+			t.Errorf("sent %d sets, want none", len(reqs))
+		}
+	})
+
+	t.Run("not finite", func(t *testing.T) {
+		core := newFakeCore(t)
+		d := newFakeDSP(core)
+
+		_, err := d.SetControl(testContext(t), "Gain", math.Inf(1))
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidParams)
+		}
+	})
+
+	t.Run("name mismatch", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Set", func(req fakeRequest) interface{} {
+			return map[string]interface{}{"Name": "Other", "Value": 1}
+		})
+
+		d := newFakeDSP(core)
+		if _, err := d.SetControl(testContext(t), "Source", 1); err == nil {
+			t.Fatal("got no error for a response about a different control")
+		}
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Set", func(req fakeRequest) interface{} {
+			return &Error{Code: -32602, Message: "Invalid params"}
+		})
+
+		d := newFakeDSP(core)
+		_, err := d.SetControl(testContext(t), "Source", 1)
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatalf("got error %v, want %v", err, ErrInvalidParams)
+		}
+	})
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{in: "-10.5", want: -10.5},
+		{in: "0", want: 0.0},
+		{in: "1e3", want: 1000.0},
+		{in: "true", want: true},
+		{in: "false", want: false},
+		{in: "007", want: "007"},
+		{in: "0x10", want: "0x10"},
+		{in: "1_000", want: "1_000"},
+		{in: "NaN", want: "NaN"},
+		{in: "Inf", want: "Inf"},
+		{in: "1e400", want: "1e400"},
+		{in: "T", want: "T"},
+		{in: "1.", want: "1."},
+		{in: "HDMI 2", want: "HDMI 2"},
+	}
+
+	for _, tt := range tests {
+		if got := parseValue(tt.in); got != tt.want {
+			t.Errorf("parseValue(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", controlValues(map[string]float64{
+		"ZoneAGain": 0,
+		"ZoneBGain": -6,
+		"ZoneCGain": -100,
+	}))
+
+	d := newFakeDSP(core)
+	vols, err := d.Volumes(testContext(t), []string{"ZoneAGain", "ZoneBGain", "ZoneCGain"})
+	if err != nil {
+		t.Fatalf("unable to get volumes: %v", err)
+	}
+
+	want := map[string]int{"ZoneAGain": 100, "ZoneBGain": 50, "ZoneCGain": 0}
+	for block, level := range want {
+		if vols[block] != level {
+			t.Errorf("got volume %d for %s, want %d", vols[block], block, level)
+		}
+	}
+
+	// every block is read with a single request
+	if reqs := core.Requests("Control.Get"); len(reqs) != 1 {
+		t.Errorf("sent %d Control.Get requests, want 1", len(reqs))
+	}
+}
+
+func TestVolumesPositionMode(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", func(req fakeRequest) interface{} {
+		return []map[string]interface{}{{"Name": "ZoneGain", "Value": -20, "Position": 0.25}}
+	})
+
+	d := newFakeDSP(core, WithVolumeMode("ZoneGain", VolumeModePosition))
+	vols, err := d.Volumes(testContext(t), []string{"ZoneGain"})
+	if err != nil {
+		t.Fatalf("unable to get volumes: %v", err)
+	}
+
+	if vols["ZoneGain"] != 25 {
+		t.Errorf("got volume %d, want 25", vols["ZoneGain"])
+	}
+}
+
+func TestSetVolume(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Set", echoSet)
+
+	d := newFakeDSP(core)
+	if err := d.SetVolume(testContext(t), "ZoneGain", 50); err != nil {
+		t.Fatalf("unable to set volume: %v", err)
+	}
+
+	params := core.setParams()
+	if len(params) != 1 {
+		t.Fatalf("got %d sets, want 1", len(params))
+	}
+
+	gain, ok := params[0].Value.(float64)
+	if !ok || math.Abs(gain-20*math.Log10(0.5)) > 0.001 {
+		t.Errorf("sent gain %v, want about -6.02", params[0].Value)
+	}
+
+	for _, volume := range []int{-1, 101} {
+		if err := d.SetVolume(testContext(t), "ZoneGain", volume); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("got error %v for volume %d, want %v", err, volume, ErrInvalidParams)
+		}
+	}
+
+	if params := core.setParams(); len(params) != 1 {
+		t.Errorf("got %d sets, want only the valid one", len(params))
+	}
+}
+
+func TestVolumeCurves(t *testing.T) {
+	table, err := NewTableCurve([]CurvePoint{{Level: 100, Gain: 10}, {Level: 0, Gain: -80}, {Level: 50, Gain: -20}})
+	if err != nil {
+		t.Fatalf("unable to create table curve: %v", err)
+	}
+
+	curves := map[string]VolumeCurve{
+		"default": DefaultVolumeCurve,
+		"log":     LogCurve{MinDB: -80, MaxDB: 12},
+		"linear":  LinearCurve{MinDB: -60, MaxDB: 0},
+		"table":   table,
+	}
+
+	for name, curve := range curves {
+		t.Run(name, func(t *testing.T) {
+			for level := 1; level <= 100; level++ {
+				gain := curve.Gain(level)
+				if got := curve.Level(gain); got != level {
+					t.Errorf("level %d is %v dB, which reads back as %d", level, gain, got)
+				}
+			}
+
+			if got := curve.Level(curve.Gain(0)); got != 0 {
+				t.Errorf("level 0 reads back as %d", got)
+			}
+		})
+	}
+}
+
+func TestDefaultVolumeCurve(t *testing.T) {
+	tests := []struct {
+		gain float64
+		want int
+	}{
+		{gain: 0, want: 100},
+		{gain: -6, want: 50},
+		{gain: -100, want: 0},
+		{gain: 6, want: 200},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultVolumeCurve.Level(tt.gain); got != tt.want {
+			t.Errorf("%v dB is level %d, want %d", tt.gain, got, tt.want)
+		}
+	}
+
+	if gain := DefaultVolumeCurve.Gain(0); gain != -100 {
+		t.Errorf("level 0 is %v dB, want -100", gain)
+	}
+}
+
+func TestTableCurve(t *testing.T) {
+	curve, err := NewTableCurve([]CurvePoint{{Level: 80, Gain: 0}, {Level: 20, Gain: -60}, {Level: 50, Gain: -20}})
+	if err != nil {
+		t.Fatalf("unable to create table curve: %v", err)
+	}
+
+	gains := []struct {
+		level int
+		want  float64
+	}{
+		{level: 0, want: -60},
+		{level: 20, want: -60},
+		{level: 35, want: -40},
+		{level: 50, want: -20},
+		{level: 65, want: -10},
+		{level: 80, want: 0},
+		{level: 100, want: 0},
+	}
+
+	for _, tt := range gains {
+		if got := curve.Gain(tt.level); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("level %d is %v dB, want %v", tt.level, got, tt.want)
+		}
+	}
+
+	levels := []struct {
+		gain float64
+		want int
+	}{
+		{gain: -100, want: 20},
+		{gain: -60, want: 20},
+		{gain: -40, want: 35},
+		{gain: -10, want: 65},
+		{gain: 0, want: 80},
+		{gain: 12, want: 80},
+	}
+
+	for _, tt := range levels {
+		if got := curve.Level(tt.gain); got != tt.want {
+			t.Errorf("%v dB is level %d, want %d", tt.gain, got, tt.want)
+		}
+	}
+
+	invalid := [][]CurvePoint{
+		{{Level: 0, Gain: -60}},
+		{{Level: 0, Gain: -60}, {Level: 101, Gain: 0}},
+		{{Level: 0, Gain: -60}, {Level: 50, Gain: -60}},
+		{{Level: 0, Gain: -60}, {Level: 0, Gain: 0}},
+	}
+
+	for _, points := range invalid {
+		if _, err := NewTableCurve(points); err == nil {
+			t.Errorf("created a table curve from %+v, want an error", points)
+		}
+	}
+}
+
+func TestSetVolumePositionMode(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Set", echoSet)
+
+	d := newFakeDSP(core, WithVolumeMode("ZoneGain", VolumeModePosition))
+	if err := d.SetVolume(testContext(t), "ZoneGain", 40); err != nil {
+		t.Fatalf("unable to set volume: %v", err)
+	}
+
+	params := core.setParams()
+	if len(params) != 1 || params[0].Position == nil || *params[0].Position != 0.4 || params[0].Value != nil {
+		t.Fatalf("sent %+v, want a position of 0.4 and no value", params)
+	}
+
+	err := d.SetVolume(testContext(t), "ZoneGain", 101)
+	if !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("got error %v for volume 101, want %v", err, ErrInvalidParams)
+	}
+}
+
+func TestStepVolume(t *testing.T) {
+	core := newFakeCore(t)
+	value := core.fakeControls(map[string]float64{"ZoneGain": DefaultVolumeCurve.Gain(8)}, time.Millisecond)
+
+	d := newFakeDSP(core)
+	level := func() int {
+		return DefaultVolumeCurve.Level(value("ZoneGain"))
+	}
+
+	// every level steps by exactly one, in both directions
+	for want := 9; want <= 100; want++ {
+		vol, err := d.StepVolume(testContext(t), "ZoneGain", 1)
+		if err != nil {
+			t.Fatalf("unable to step volume: %v", err)
+		}
+
+		if vol != want || level() != want {
+			t.Fatalf("stepped to %d (core at %d), want %d", vol, level(), want)
+		}
+	}
+
+	if vol, err := d.StepVolume(testContext(t), "ZoneGain", 5); err != nil || vol != 100 {
+		t.Errorf("got %d, %v stepping above 100, want 100", vol, err)
+	}
+
+	for want := 99; want >= 0; want-- {
+		vol, err := d.StepVolume(testContext(t), "ZoneGain", -1)
+		if err != nil {
+			t.Fatalf("unable to step volume: %v", err)
+		}
+
+		if vol != want || level() != want {
+			t.Fatalf("stepped to %d (core at %d), want %d", vol, level(), want)
+		}
+	}
+
+	if vol, err := d.StepVolume(testContext(t), "ZoneGain", -5); err != nil || vol != 0 {
+		t.Errorf("got %d, %v stepping below 0, want 0", vol, err)
+	}
+
+	// presses at the same time each start from the volume the one before set
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := d.StepVolume(testContext(t), "ZoneGain", 2); err != nil {
+				t.Errorf("unable to step volume: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if level() != 20 {
+		t.Errorf("got volume %d after 10 steps of 2 at once, want 20", level())
+	}
+}
+
+func TestToggleMute(t *testing.T) {
+	core := newFakeCore(t)
+	value := core.fakeControls(map[string]float64{"ZoneMute": 0}, time.Millisecond)
+
+	d := newFakeDSP(core)
+	for _, want := range []bool{true, false, true} {
+		muted, err := d.ToggleMute(testContext(t), "ZoneMute")
+		if err != nil {
+			t.Fatalf("unable to toggle mute: %v", err)
+		}
+
+		if muted != want || (value("ZoneMute") == 1) != want {
+			t.Fatalf("toggled to %v (core at %v), want %v", muted, value("ZoneMute"), want)
+		}
+	}
+
+	// toggles at the same time each flip the mute the one before set, so an even number of them cancel out
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := d.ToggleMute(testContext(t), "ZoneMute"); err != nil {
+				t.Errorf("unable to toggle mute: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value("ZoneMute") != 1 {
+		t.Errorf("got mute %v after 10 toggles at once, want it still muted", value("ZoneMute"))
+	}
+
+	if n := len(core.Requests("Control.Set")); n != 13 {
+		t.Errorf("sent %d sets, want 13", n)
+	}
+}
+
+func TestMutes(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", controlValues(map[string]float64{
+		"ZoneAMute": 1,
+		"ZoneBMute": 0,
+		"Broken":    0.5,
+	}))
+
+	d := newFakeDSP(core)
+	mutes, err := d.Mutes(testContext(t), []string{"ZoneAMute", "ZoneBMute"})
+	if err != nil {
+		t.Fatalf("unable to get mutes: %v", err)
+	}
+
+	if !mutes["ZoneAMute"] || mutes["ZoneBMute"] {
+		t.Errorf("got %v, want ZoneAMute muted and ZoneBMute unmuted", mutes)
+	}
+
+	if _, err := d.Mutes(testContext(t), []string{"Broken"}); err == nil {
+		t.Error("got no error for a mute value of 0.5")
+	}
+}
+
+func TestSetMute(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Set", echoSet)
+
+	d := newFakeDSP(core)
+	ctx := testContext(t)
+
+	if err := d.SetMute(ctx, "ZoneMute", true); err != nil {
+		t.Fatalf("unable to mute: %v", err)
+	}
+
+	if err := d.SetMute(ctx, "ZoneMute", false); err != nil {
+		t.Fatalf("unable to unmute: %v", err)
+	}
+
+	params := core.setParams()
+	if len(params) != 2 || params[0].Value != 1.0 || params[1].Value != 0.0 {
+		t.Errorf("sent %+v, want a value of 1 then 0", params)
+	}
+}
+
+func TestSetControls(t *testing.T) {
+	core := newFakeCore(t)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(req fakeRequest) interface{} {
+		var params struct {
+			Name     string
+			Controls []QSCSetStatusParams
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return &Error{Code: -32602, Message: err.Error()}
+		}
+
+		name := params.Name
+		if len(params.Controls) > 0 {
+			name += "." + params.Controls[0].Name
+		}
+
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+
+		if req.Method == "Control.Set" {
+			return echoSet(req)
+		}
+
+		return true
+	}
+	core.Handle("Control.Set", record)
+	core.Handle("Component.Set", record)
+
+	d := newFakeDSP(core)
+	errs := d.SetControls(testContext(t), []ControlSet{
+		{Control: "Source", Value: 1},
+		{Component: "Mixer", Control: "input.1.gain", Value: -10},
+		{Control: ""},
+		{Control: "Mute", Value: true},
+		{Component: "Mixer", Control: "input.1.mute", Value: false},
+	})
+
+	for i, err := range errs {
+		if i == 2 {
+			if !errors.Is(err, ErrInvalidParams) {
+				t.Errorf("set %d: got error %v, want %v", i, err, ErrInvalidParams)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("set %d: unable to set control: %v", i, err)
+		}
+	}
+
+	want := []string{"Source", "Mixer.input.1.gain", "Mute", "Mixer.input.1.mute"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("sets were sent in the order %v, want %v", order, want)
+	}
+}
+
+func TestSetComponent(t *testing.T) {
+	core := newFakeCore(t)
+	d := newFakeDSP(core, WithPollRate(time.Hour))
+
+	sub := d.Subscribe()
+	defer sub.Close(testContext(t))
+
+	if err := sub.Add(testContext(t), nil, map[string][]string{"Mixer": {"gain", "mute"}}); err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	d.changes.update([]QSCChange{{Component: "Mixer", Name: "gain", Value: -10}, {Component: "Mixer", Name: "mute", Value: 0}})
+
+	if err := d.SetComponent(testContext(t), "Mixer", map[string]interface{}{"gain": -3, "mute": true}); err != nil {
+		t.Fatalf("unable to set component: %v", err)
+	}
+
+	reqs := core.Requests("Component.Set")
+	if len(reqs) != 1 {
+		t.Fatalf("got %d Component.Set requests, want 1", len(reqs))
+	}
+
+	// reads right after the set get the new values without waiting for the next poll
+	if val, ok := d.changes.Value("Mixer", "gain"); !ok || val.Value != -3 {
+		t.Errorf("got cached gain %+v, want -3", val)
+	}
+
+	if val, ok := d.changes.Value("Mixer", "mute"); !ok || val.Value != 1 {
+		t.Errorf("got cached mute %+v, want 1", val)
+	}
+
+	core.Handle("Component.Set", func(req fakeRequest) interface{} {
+		return &Error{Code: 8, Message: "Unknown control"}
+	})
+
+	if err := d.SetComponent(testContext(t), "Mixer", map[string]interface{}{"gain": 0}); !errors.Is(err, ErrInvalidControl) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidControl)
+	}
+
+	if val, _ := d.changes.Value("Mixer", "gain"); val.Value != -3 {
+		t.Errorf("got cached gain %v after a failed set, want -3", val.Value)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	core := newFakeCore(t)
+	d := newFakeDSP(core)
+
+	if err := d.LoadSnapshot(testContext(t), "Rooms", 3, 1500*time.Millisecond); err != nil {
+		t.Fatalf("unable to load snapshot: %v", err)
+	}
+
+	if err := d.SaveSnapshot(testContext(t), "Rooms", 4); err != nil {
+		t.Fatalf("unable to save snapshot: %v", err)
+	}
+
+	load := core.Requests("Snapshot.Load")
+	if len(load) != 1 || string(load[0].Params) != `{"Name":"Rooms","Bank":3,"Ramp":1.5}` {
+		t.Errorf("got Snapshot.Load requests %+v, want Rooms 3 over 1.5 seconds", load)
+	}
+
+	save := core.Requests("Snapshot.Save")
+	if len(save) != 1 || string(save[0].Params) != `{"Name":"Rooms","Bank":4}` {
+		t.Errorf("got Snapshot.Save requests %+v, want Rooms 4", save)
+	}
+
+	tests := []struct {
+		name   string
+		bank   string
+		number int
+		ramp   time.Duration
+	}{
+		{name: "no bank", number: 1},
+		{name: "number 0", bank: "Rooms"},
+		{name: "negative number", bank: "Rooms", number: -1},
+		{name: "negative ramp", bank: "Rooms", number: 1, ramp: -time.Second},
+	}
+
+	for _, tt := range tests {
+		if err := d.LoadSnapshot(testContext(t), tt.bank, tt.number, tt.ramp); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%s: got error %v loading, want %v", tt.name, err, ErrInvalidParams)
+		}
+
+		if tt.ramp != 0 {
+			continue
+		}
+
+		if err := d.SaveSnapshot(testContext(t), tt.bank, tt.number); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%s: got error %v saving, want %v", tt.name, err, ErrInvalidParams)
+		}
+	}
+
+	if _, err := parseSnapshotNumber("two"); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("got error %v parsing a number that isn't one, want %v", err, ErrInvalidParams)
+	}
+
+	if n := len(core.Requests("Snapshot.Load")) + len(core.Requests("Snapshot.Save")); n != 2 {
+		t.Errorf("sent %d snapshot requests, want only the 2 valid ones", n)
+	}
+
+	core.Handle("Snapshot.Load", func(req fakeRequest) interface{} {
+		return &Error{Code: 8, Message: "Unknown component"}
+	})
+
+	if err := d.LoadSnapshot(testContext(t), "Missing", 1, 0); !errors.Is(err, ErrInvalidControl) {
+		t.Errorf("got error %v for a bank that doesn't exist, want %v", err, ErrInvalidControl)
+	}
+}
+
+func TestLoopPlayer(t *testing.T) {
+	core := newFakeCore(t)
+	d := newFakeDSP(core)
+
+	start := 3600.0
+	job := LoopPlayerJob{
+		Files: []LoopPlayerFile{
+			{Name: "Audio/chime.wav", Output: 1},
+			{Name: "Audio/music.wav", Mode: "stereo", Output: 2, Seek: 12.5},
+		},
+		StartTime: &start,
+		Loop:      true,
+		Log:       true,
+	}
+
+	if err := d.StartLoopPlayer(testContext(t), "Player", job); err != nil {
+		t.Fatalf("unable to start loop player: %v", err)
+	}
+
+	reqs := core.Requests("LoopPlayer.Start")
+	want := `{"Name":"Player","Files":[{"Name":"Audio/chime.wav","Mode":"mono","Output":1},` +
+		`{"Name":"Audio/music.wav","Mode":"stereo","Output":2,"Seek":12.5}],"StartTime":3600,"Loop":true,"Log":true}`
+	if len(reqs) != 1 || string(reqs[0].Params) != want {
+		t.Errorf("got LoopPlayer.Start requests %+v, want %s", reqs, want)
+	}
+
+	invalid := []struct {
+		name  string
+		files []LoopPlayerFile
+	}{
+		{name: "no files"},
+		{name: "no name", files: []LoopPlayerFile{{Output: 1}}},
+		{name: "output 0", files: []LoopPlayerFile{{Name: "a.wav"}}},
+		{name: "negative seek", files: []LoopPlayerFile{{Name: "a.wav", Output: 1, Seek: -1}}},
+		{name: "bad mode", files: []LoopPlayerFile{{Name: "a.wav", Output: 1, Mode: "surround"}}},
+	}
+
+	for _, tt := range invalid {
+		if err := d.StartLoopPlayer(testContext(t), "Player", LoopPlayerJob{Files: tt.files}); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, ErrInvalidParams)
+		}
+	}
+
+	if err := d.StopLoopPlayer(testContext(t), "Player", nil); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("got error %v stopping no outputs, want %v", err, ErrInvalidParams)
+	}
+
+	if n := len(core.Requests("LoopPlayer.Start")) + len(core.Requests("LoopPlayer.Stop")); n != 1 {
+		t.Errorf("sent %d loop player requests, want only the valid one", n)
+	}
+
+	if err := d.CancelLoopPlayer(testContext(t), "Player", []int{1, 2}); err != nil {
+		t.Fatalf("unable to cancel loop player: %v", err)
+	}
+
+	reqs = core.Requests("LoopPlayer.Cancel")
+	if len(reqs) != 1 || string(reqs[0].Params) != `{"Name":"Player","Outputs":[1,2],"Log":false}` {
+		t.Errorf("got LoopPlayer.Cancel requests %+v, want outputs 1 and 2 of Player", reqs)
+	}
+}
+
+func TestPlayerStatus(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Component.GetControls", func(req fakeRequest) interface{} {
+		return QSCGetControlsResult{
+			Name: "Player",
+			Controls: []QSCControl{
+				{Name: "output.2.playing", Value: 1},
+				{Name: "output.2.progress", Position: 0.25},
+				{Name: "output.2.file.name", String: "Audio/music.wav"},
+				{Name: "output.1.playing", Value: 0},
+				{Name: "output.1.progress", Position: 0},
+				{Name: "gain", Value: -6},
+			},
+		}
+	})
+
+	d := newFakeDSP(core)
+	status, err := d.PlayerStatus(testContext(t), "Player")
+	if err != nil {
+		t.Fatalf("unable to get player status: %v", err)
+	}
+
+	if status.Name != "Player" || !status.Playing || status.Progress != nil || status.File != "" {
+		t.Errorf("got status %+v, want Player playing with no progress or file of its own", status)
+	}
+
+	if len(status.Outputs) != 2 {
+		t.Fatalf("got outputs %+v, want 2", status.Outputs)
+	}
+
+	first, second := status.Outputs[0], status.Outputs[1]
+	if first.Output != 1 || first.Playing || first.Progress == nil || *first.Progress != 0 {
+		t.Errorf("got output %+v, want output 1 stopped at the start", first)
+	}
+
+	if second.Output != 2 || !second.Playing || second.Progress == nil || *second.Progress != 0.25 || second.File != "Audio/music.wav" {
+		t.Errorf("got output %+v, want output 2 playing Audio/music.wav a quarter of the way through", second)
+	}
+
+	if len(status.Controls) != 6 {
+		t.Errorf("got %d controls, want all 6", len(status.Controls))
+	}
+
+	reqs := core.Requests("Component.GetControls")
+	if len(reqs) != 1 || string(reqs[0].Params) != `{"Name":"Player"}` {
+		t.Errorf("got Component.GetControls requests %+v, want the controls of Player", reqs)
+	}
+}
+
+func TestSetMuteInvalidResponse(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Set", func(req fakeRequest) interface{} {
+		return map[string]interface{}{"Name": "ZoneMute", "Value": 0.5}
+	})
+
+	d := newFakeDSP(core)
+	if err := d.SetMute(testContext(t), "ZoneMute", true); err == nil {
+		t.Fatal("got no error for a mute value of 0.5")
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("StatusGet", func(req fakeRequest) interface{} {
+		return core.status
+	})
+
+	d := newFakeDSP(core)
+	resp, err := d.GetStatus(testContext(t))
+	if err != nil {
+		t.Fatalf("unable to get status: %v", err)
+	}
+
+	if resp.Result != core.status {
+		t.Errorf("got %+v, want %+v", resp.Result, core.status)
+	}
+}
+
+func TestEngineStatus(t *testing.T) {
+	core := newFakeCore(t)
+
+	d := newFakeDSP(core)
+	status, err := d.EngineStatus(testContext(t))
+	if err != nil {
+		t.Fatalf("unable to get engine status: %v", err)
+	}
+
+	// the status comes from the notification sent when connecting, not a request
+	if status != core.status {
+		t.Errorf("got %+v, want %+v", status, core.status)
+	}
+
+	if reqs := core.Requests("StatusGet"); len(reqs) != 0 {
+		t.Errorf("sent %d StatusGet requests, want none", len(reqs))
+	}
+
+	// cores that require a logon don't send their status until asked
+	core = newFakeCore(t)
+	core.noStatus = true
+
+	d = newFakeDSP(core)
+	_, err = d.EngineStatus(testContext(t))
+	if !errors.Is(err, ErrConnection) {
+		t.Errorf("got error %v without a status, want %v", err, ErrConnection)
+	}
+
+	if status := httpStatus(err); status != http.StatusBadGateway {
+		t.Errorf("got status %d without a status, want %d", status, http.StatusBadGateway)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("StatusGet", func(req fakeRequest) interface{} {
+		return core.status
+	})
+
+	d := newFakeDSP(core)
+	resp, err := d.Info(testContext(t))
+	if err != nil {
+		t.Fatalf("unable to get info: %v", err)
+	}
+
+	info, ok := resp.(Info)
+	if !ok {
+		t.Fatalf("got %T, want Info", resp)
+	}
+
+	if info.IPAddress != "127.0.0.1" {
+		t.Errorf("got ip address %q, want %q", info.IPAddress, "127.0.0.1")
+	}
+
+	if info.ModelName != "Core 110f" || info.State != "Active" || info.StatusCode != "OK" {
+		t.Errorf("got %+v, want the model, state, and status of the core", info)
+	}
+
+	var raw QSCStatusGetResponse
+	if err := json.Unmarshal([]byte(info.RawState), &raw); err != nil || raw.Result != core.status {
+		t.Errorf("got raw state %s, want the StatusGet response", info.RawState)
+	}
+}
+
+func TestInfoError(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("StatusGet", func(req fakeRequest) interface{} {
+		return &Error{Code: 10, Message: "Logon required"}
+	})
+
+	d := newFakeDSP(core)
+	_, err := d.Info(testContext(t))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got error %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestLogon(t *testing.T) {
+	newCore := func(t *testing.T) *fakeCore {
+		core := newFakeCore(t)
+
+		var loggedOn atomic.Bool
+		core.Handle("Logon", func(req fakeRequest) interface{} {
+			var params QSCLogonParams
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.User != "admin" || params.Password != "1234" {
+				return &Error{Code: 10, Message: "Logon failed"}
+			}
+
+			loggedOn.Store(true)
+			return true
+		})
+
+		core.Handle("Control.Get", func(req fakeRequest) interface{} {
+			if !loggedOn.Load() {
+				return &Error{Code: 10, Message: "Logon required"}
+			}
+
+			return controlValues(map[string]float64{"Gain": -10})(req)
+		})
+
+		return core
+	}
+
+	t.Run("logon", func(t *testing.T) {
+		core := newCore(t)
+		d := newFakeDSP(core, WithCredentials(StaticCredentials("admin", "1234")))
+
+		res, err := d.Control(testContext(t), "Gain")
+		if err != nil {
+			t.Fatalf("unable to get control: %v", err)
+		}
+
+		if res.Value != -10 {
+			t.Errorf("got value %v, want -10", res.Value)
+		}
+
+		reqs := core.Requests("Logon")
+		if len(reqs) != 1 || string(reqs[0].Params) != `{"User":"admin","Password":"1234"}` {
+			t.Errorf("got Logon requests %+v, want a single logon as admin", reqs)
+		}
+	})
+
+	t.Run("bad credentials", func(t *testing.T) {
+		core := newCore(t)
+		d := newFakeDSP(core, WithCredentials(StaticCredentials("admin", "0000")))
+
+		_, err := d.Control(testContext(t), "Gain")
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("got error %v, want %v", err, ErrUnauthorized)
+		}
+
+		if status := httpStatus(err); status != http.StatusUnauthorized {
+			t.Errorf("got status %d, want %d", status, http.StatusUnauthorized)
+		}
+
+		// the connection that failed to log on is closed before anything else is sent on it
+		if n := len(core.Requests("Control.Get")); n != 0 {
+			t.Errorf("sent %d Control.Get requests, want none", n)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		core := newCore(t)
+		d := newFakeDSP(core)
+
+		_, err := d.Control(testContext(t), "Gain")
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("got error %v, want %v", err, ErrUnauthorized)
+		}
+
+		if status := httpStatus(err); status != http.StatusUnauthorized {
+			t.Errorf("got status %d, want %d", status, http.StatusUnauthorized)
+		}
+
+		if n := len(core.Requests("Logon")); n != 0 {
+			t.Errorf("sent %d Logon requests without credentials, want none", n)
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Control.Get", func(req fakeRequest) interface{} {
+		return fakeNoResponse
+	})
+	core.Handle("Control.Set", func(req fakeRequest) interface{} {
+		return fakeNoResponse
+	})
+	core.Handle("StatusGet", func(req fakeRequest) interface{} {
+		return fakeNoResponse
+	})
+
+	d := newFakeDSP(core)
+
+	calls := map[string]func(ctx context.Context) error{
+		"Control": func(ctx context.Context) error {
+			_, err := d.Control(ctx, "Gain")
+			return err
+		},
+		"SetControl": func(ctx context.Context) error {
+			_, err := d.SetControl(ctx, "Gain", 1)
+			return err
+		},
+		"Volumes": func(ctx context.Context) error {
+			_, err := d.Volumes(ctx, []string{"Gain"})
+			return err
+		},
+		"SetVolume": func(ctx context.Context) error {
+			return d.SetVolume(ctx, "Gain", 50)
+		},
+		"Mutes": func(ctx context.Context) error {
+			_, err := d.Mutes(ctx, []string{"Mute"})
+			return err
+		},
+		"SetMute": func(ctx context.Context) error {
+			return d.SetMute(ctx, "Mute", true)
+		},
+		"GetStatus": func(ctx context.Context) error {
+			_, err := d.GetStatus(ctx)
+			return err
+		},
+		"Info": func(ctx context.Context) error {
+			_, err := d.Info(ctx)
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			err := call(ctx)
+			if !errors.Is(err, ErrTimeout) {
+				t.Fatalf("got error %v, want %v", err, ErrTimeout)
+			}
+
+			if status := httpStatus(err); status != http.StatusGatewayTimeout {
+				t.Errorf("got status %d, want %d", status, http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+func TestConnection(t *testing.T) {
+	t.Run("slow dial", func(t *testing.T) {
+		core := newFakeCore(t)
+		release := make(chan struct{})
+		defer close(release)
+
+		d := newFakeDSP(core, WithTransport(func(ctx context.Context, addr string) (Transport, error) {
+			<-release
+			return core.Dial(ctx, addr)
+		}))
+
+		// every caller gives up when its own context is done, instead of waiting behind the dial
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			_, err := d.Control(ctx, "Gain")
+			cancel()
+
+			if !errors.Is(err, ErrTimeout) {
+				t.Fatalf("got error %v, want %v", err, ErrTimeout)
+			}
+		}
+	})
+
+	t.Run("write timeout", func(t *testing.T) {
+		core := newFakeCore(t)
+		d := newFakeDSP(core, WithTransport(func(ctx context.Context, addr string) (Transport, error) {
+			conn, err := core.Dial(ctx, addr)
+			return slowWriter{conn}, err
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err := d.Control(ctx, "Gain")
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("got error %v, want %v", err, ErrTimeout)
+		}
+	})
+
+	t.Run("closed before send", func(t *testing.T) {
+		core := newFakeCore(t)
+		d := newFakeDSP(core)
+
+		conn, err := d.client.connection(testContext(t))
+		if err != nil {
+			t.Fatalf("unable to connect: %v", err)
+		}
+
+		conn.close(errIdle)
+
+		req := d.GetGenericGetStatusRequest(testContext(t))
+		_, err = d.client.start(testContext(t), conn, req, true)
+		if !errors.Is(err, ErrConnection) || !errors.Is(err, errClosed) {
+			t.Fatalf("got error %v, want %v", err, ErrConnection)
+		}
+
+		// the next request is sent on a new connection
+		p, err := d.client.Start(testContext(t), req)
+		if err != nil {
+			t.Fatalf("unable to start request: %v", err)
+		}
+
+		if _, err := p.Wait(testContext(t)); err != nil {
+			t.Fatalf("unable to get response: %v", err)
+		}
+	})
+
+	t.Run("keepalive keeps the connection open until the ttl", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Get", controlValues(map[string]float64{"Gain": 1}))
+
+		var dials atomic.Int32
+		d := newFakeDSP(core, WithTTL(100*time.Millisecond), WithKeepAlive(5*time.Millisecond), WithTransport(func(ctx context.Context, addr string) (Transport, error) {
+			dials.Add(1)
+			return core.Dial(ctx, addr)
+		}))
+
+		for i := 0; i < 3; i++ {
+			if _, err := d.Control(testContext(t), "Gain"); err != nil {
+				t.Fatalf("unable to get control: %v", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+		}
+
+		if n := dials.Load(); n != 1 {
+			t.Errorf("dialed %d times while in use, want 1", n)
+		}
+
+		if len(core.Requests("NoOp")) == 0 {
+			t.Error("never sent a keepalive")
+		}
+
+		// keepalives don't keep an unused connection open past the ttl
+		time.Sleep(200 * time.Millisecond)
+
+		if _, err := d.Control(testContext(t), "Gain"); err != nil {
+			t.Fatalf("unable to get control: %v", err)
+		}
+
+		if n := dials.Load(); n != 2 {
+			t.Errorf("dialed %d times, want the idle connection closed and a new one opened", n)
+		}
+	})
+
+	t.Run("redial stops without requests", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Get", controlValues(map[string]float64{"Gain": 1}))
+
+		var mu sync.Mutex
+		var conn Transport
+		var dials int
+		d := newFakeDSP(core, WithTTL(50*time.Millisecond), WithKeepAlive(5*time.Millisecond), WithTransport(func(ctx context.Context, addr string) (Transport, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			dials++
+			if conn != nil {
+				return nil, errors.New("core is down")
+			}
+
+			var err error
+			conn, err = core.Dial(ctx, addr)
+			return conn, err
+		}))
+
+		if _, err := d.Control(testContext(t), "Gain"); err != nil {
+			t.Fatalf("unable to get control: %v", err)
+		}
+
+		mu.Lock()
+		conn.Close()
+		mu.Unlock()
+
+		time.Sleep(150 * time.Millisecond)
+
+		mu.Lock()
+		stopped := dials
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if dials == 1 {
+			t.Error("never tried to reconnect")
+		}
+
+		if dials != stopped {
+			t.Errorf("still reconnecting %v after the last request", 200*time.Millisecond)
+		}
+	})
+}
+
+// slowWriter is a connection that never finishes writing before the deadline.
+type slowWriter struct {
+	Transport
+}
+
+func (s slowWriter) WriteFrame(frame []byte, deadline time.Time) error {
+	time.Sleep(time.Until(deadline))
+	return os.ErrDeadlineExceeded
+}
+
+func TestMalformedResponse(t *testing.T) {
+	t.Run("wrong result type", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Get", func(req fakeRequest) interface{} {
+			return "not a list of controls"
+		})
+
+		d := newFakeDSP(core)
+		_, err := d.Volumes(testContext(t), []string{"Gain"})
+		if err == nil || !strings.Contains(err.Error(), "unable to parse response") {
+			t.Fatalf("got error %v, want a parse error", err)
+		}
+	})
+
+	t.Run("wrong set result type", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Set", func(req fakeRequest) interface{} {
+			return []string{"not", "a", "control"}
+		})
+
+		d := newFakeDSP(core)
+		if err := d.SetMute(testContext(t), "Mute", true); err == nil {
+			t.Fatal("got no error for a malformed set response")
+		}
+	})
+
+	t.Run("not json", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Get", func(req fakeRequest) interface{} {
+			return fakeRaw(`{"jsonrpc":"2.0","id":` + strings.Repeat("}", 3))
+		})
+
+		// frames that can't be parsed can't be matched to a request, so the request times out
+		d := newFakeDSP(core)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err := d.Control(ctx, "Gain")
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("got error %v, want %v", err, ErrTimeout)
+		}
+	})
+
+	for _, value := range []string{`{}`, `[1]`} {
+		t.Run("bad value "+value, func(t *testing.T) {
+			core := newFakeCore(t)
+			core.Handle("Control.Get", func(req fakeRequest) interface{} {
+				return fakeRaw(`{"jsonrpc":"2.0","id":` + strconv.Itoa(req.ID) + `,"result":[{"Name":"Gain","Value":` + value + `}]}`)
+			})
+
+			d := newFakeDSP(core)
+			_, err := d.Control(testContext(t), "Gain")
+			if err == nil {
+				t.Fatalf("got no error for a value of %s", value)
+			}
+		})
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	t.Run("failed add", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("ChangeGroup.AddComponentControl", func(req fakeRequest) interface{} {
+			return &Error{Code: 8, Message: "Unknown component"}
+		})
+
+		d := newFakeDSP(core, WithPollRate(time.Hour))
+		sub := d.Subscribe()
+		defer sub.Close(testContext(t))
+
+		err := sub.Add(testContext(t), []string{"Gain"}, map[string][]string{"Mixer": {"input.1.gain"}})
+		if err == nil {
+			t.Fatal("got no error for a component that couldn't be added")
+		}
+
+		// the named control that was added is removed again, so nothing is left referencing it
+		if _, ok := d.changes.controls["Gain"]; ok {
+			t.Error("Gain is still in the change group")
+		}
+
+		if n := d.subs.refs[controlKey{name: "Gain"}]; n != 0 {
+			t.Errorf("Gain has %d references, want 0", n)
+		}
+
+		if len(sub.keys) != 0 {
+			t.Errorf("subscribed to %d controls, want none", len(sub.keys))
+		}
+	})
+
+	t.Run("failed release", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("ChangeGroup.Remove", func(req fakeRequest) interface{} {
+			return &Error{Code: 6, Message: "Bad change group"}
+		})
+		core.Handle("ChangeGroup.Destroy", func(req fakeRequest) interface{} {
+			return &Error{Code: 6, Message: "Bad change group"}
+		})
+
+		d := newFakeDSP(core, WithPollRate(time.Hour))
+		sub := d.Subscribe()
+
+		if err := sub.Add(testContext(t), []string{"Gain"}, map[string][]string{"Mixer": {"input.1.gain"}}); err != nil {
+			t.Fatalf("unable to subscribe: %v", err)
+		}
+
+		var qscErr *Error
+		if err := sub.Close(testContext(t)); !errors.As(err, &qscErr) {
+			t.Fatalf("got error %v, want an error from the core", err)
+		}
+
+		// the component is released even though the named control failed first
+		if len(d.subs.refs) != 0 {
+			t.Errorf("%d controls still have references, want none", len(d.subs.refs))
+		}
+
+		if len(d.changes.components) != 0 {
+			t.Error("the component controls are still in the change group")
+		}
+
+		// the empty group's connection is closed, which removes the component controls from the core
+		if d.changes.connected() {
+			t.Error("the empty change group's connection is still open")
+		}
+	})
+
+	t.Run("unread controls are unwatched", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("Control.Get", controlValues(map[string]float64{"Gain": 0}))
+
+		d := newFakeDSP(core, WithPollRate(time.Hour), WithTTL(50*time.Millisecond))
+		defer d.Close()
+
+		if _, err := d.Volumes(testContext(t), []string{"Gain"}); err != nil {
+			t.Fatalf("unable to get volumes: %v", err)
+		}
+
+		watched := func() bool {
+			d.changes.mu.Lock()
+			defer d.changes.mu.Unlock()
+
+			_, ok := d.changes.controls["Gain"]
+			return ok
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if !watched() {
+			t.Fatal("Gain was never added to the change group")
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if watched() {
+			t.Error("Gain is still in the change group after it stopped being read")
+		}
+
+		if n := len(core.Requests("ChangeGroup.AddControl")); n != 1 {
+			t.Errorf("sent %d ChangeGroup.AddControl requests, want 1", n)
+		}
+	})
+
+	t.Run("closing while watching", func(t *testing.T) {
+		core := newFakeCore(t)
+
+		added := make(chan struct{})
+		unblock := make(chan struct{})
+		core.Handle("ChangeGroup.AddControl", func(req fakeRequest) interface{} {
+			close(added)
+			<-unblock
+			return true
+		})
+
+		d := newFakeDSP(core, WithPollRate(time.Hour), WithTTL(time.Hour))
+		defer d.Close()
+
+		d.watch("Gain")
+		<-added
+
+		// the watch finishes adding Gain after the watches are closed
+		d.subs.close()
+		close(unblock)
+
+		refs := func() int {
+			d.subs.refsMu.Lock()
+			defer d.subs.refsMu.Unlock()
+
+			return d.subs.refs[controlKey{name: "Gain"}]
+		}
+
+		for start := time.Now(); refs() != 0; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatal("Gain was never released")
+			}
+		}
+
+		d.subs.watchMu.Lock()
+		expiry, watched := d.subs.expiry, len(d.subs.watched)
+		d.subs.watchMu.Unlock()
+
+		if expiry != nil || watched != 0 {
+			t.Errorf("got %d watched controls and expiry %v after closing, want none", watched, expiry)
+		}
+
+		d.watch("Mute")
+		if n := len(core.Requests("ChangeGroup.AddControl")); n != 1 {
+			t.Errorf("sent %d ChangeGroup.AddControl requests, want only the one from before closing", n)
+		}
+	})
+
+	t.Run("empty group closes its connection", func(t *testing.T) {
+		core := newFakeCore(t)
+		d := newFakeDSP(core, WithPollRate(time.Hour))
+		sub := d.Subscribe()
+
+		if err := sub.Add(testContext(t), []string{"Gain"}, nil); err != nil {
+			t.Fatalf("unable to subscribe: %v", err)
+		}
+
+		if err := sub.Close(testContext(t)); err != nil {
+			t.Fatalf("unable to close subscription: %v", err)
+		}
+
+		if d.changes.connected() {
+			t.Error("the empty change group's connection is still open")
+		}
+
+		// adding a control again creates the group on a new connection
+		sub = d.Subscribe()
+		defer sub.Close(testContext(t))
+
+		if err := sub.Add(testContext(t), []string{"Gain"}, nil); err != nil {
+			t.Fatalf("unable to subscribe again: %v", err)
+		}
+
+		if n := len(core.Requests("ChangeGroup.AddControl")); n != 2 {
+			t.Errorf("sent %d ChangeGroup.AddControl requests, want 2", n)
+		}
+	})
+}
+
+func TestMixerMatrix(t *testing.T) {
+	core := newFakeCore(t)
+	core.Handle("Component.GetControls", func(req fakeRequest) interface{} {
+		return QSCGetControlsResult{Name: "Mixer", Controls: []QSCControl{
+			{Name: "input.1.gain", Value: -10},
+			{Name: "input.1.label", String: "Mic 1"},
+			{Name: "input.2.mute", Value: 1},
+			{Name: "output.1.gain", Value: -3},
+			{Name: "input.2.output.1.gain", Value: -6},
+			{Name: "input.1.output.1.mute", Value: 1},
+			{Name: "master.gain", Value: 0},
+		}}
+	})
+
+	d := newFakeDSP(core)
+	matrix, err := d.MixerMatrix(testContext(t), "Mixer")
+	if err != nil {
+		t.Fatalf("unable to read mixer: %v", err)
+	}
+
+	if len(matrix.Inputs) != 2 || len(matrix.Outputs) != 1 {
+		t.Fatalf("got %d inputs and %d outputs, want 2 and 1", len(matrix.Inputs), len(matrix.Outputs))
+	}
+
+	if in := matrix.Inputs[0]; in.Label != "Mic 1" || in.Gain == nil || *in.Gain != -10 {
+		t.Errorf("got input 1 %+v, want Mic 1 at -10", in)
+	}
+
+	if in := matrix.Inputs[1]; in.Mute == nil || !*in.Mute {
+		t.Errorf("got input 2 %+v, want it muted", in)
+	}
+
+	if cp := matrix.CrossPoints[1][0]; cp.Gain == nil || *cp.Gain != -6 {
+		t.Errorf("got crosspoint 2-1 %+v, want -6", cp)
+	}
+
+	if cp := matrix.CrossPoints[0][0]; cp.Mute == nil || !*cp.Mute {
+		t.Errorf("got crosspoint 1-1 %+v, want it muted", cp)
+	}
+
+	if reqs := core.Requests("Component.Get"); len(reqs) != 0 {
+		t.Errorf("sent %d Component.Get requests, want none", len(reqs))
+	}
+
+	// once the mixer's size is known, only its matrix controls are read
+	core.Handle("Component.Get", func(req fakeRequest) interface{} {
+		return QSCComponentGetResult{Name: "Mixer", Controls: []QSCGetStatusResult{
+			{Name: "input.1.gain", Value: -20},
+			{Name: "input.1.label", String: "Mic 1"},
+			{Name: "input.2.mute", Value: 0},
+			{Name: "output.1.gain", Value: -3},
+			{Name: "input.2.output.1.gain", Value: -6},
+			{Name: "input.1.output.1.mute", Value: 1},
+		}}
+	})
+
+	matrix, err = d.MixerMatrix(testContext(t), "Mixer")
+	if err != nil {
+		t.Fatalf("unable to read mixer again: %v", err)
+	}
+
+	if in := matrix.Inputs[0]; in.Gain == nil || *in.Gain != -20 {
+		t.Errorf("got input 1 %+v, want -20", in)
+	}
+
+	reqs := core.Requests("Component.Get")
+	if len(reqs) != 1 || len(core.Requests("Component.GetControls")) != 1 {
+		t.Fatalf("sent %d Component.Get requests, want the second read to send 1", len(reqs))
+	}
+
+	var params QSCComponentGetParams
+	if err := json.Unmarshal(reqs[0].Params, &params); err != nil || len(params.Controls) != 6 {
+		t.Errorf("asked for %s, want only the 6 matrix controls", reqs[0].Params)
+	}
+
+	// a mixer that changed is listed again
+	core.Handle("Component.Get", func(req fakeRequest) interface{} {
+		return &Error{Code: 8, Message: "Unknown control"}
+	})
+
+	if _, err := d.MixerMatrix(testContext(t), "Mixer"); err != nil {
+		t.Fatalf("unable to read changed mixer: %v", err)
+	}
+
+	if n := len(core.Requests("Component.GetControls")); n != 2 {
+		t.Errorf("sent %d Component.GetControls requests, want 2", n)
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	tests := []struct {
+		channels string
+		valid    bool
+	}{
+		{channels: "1", valid: true},
+		{channels: "1-4", valid: true},
+		{channels: "1-4 6 8-9", valid: true},
+		{channels: "3-3", valid: true},
+		{channels: "*", valid: true},
+		{channels: "* !3", valid: true},
+		{channels: "1-8 !2-3 !5", valid: true},
+		{channels: "!*", valid: true},
+		{channels: "  2   4 ", valid: true},
+		{channels: ""},
+		{channels: "   "},
+		{channels: "0"},
+		{channels: "-1"},
+		{channels: "4-1"},
+		{channels: "1-"},
+		{channels: "-4"},
+		{channels: "1-4-6"},
+		{channels: "a"},
+		{channels: "1,2"},
+		{channels: "!"},
+		{channels: "!!1"},
+		{channels: "**"},
+	}
+
+	for _, tt := range tests {
+		err := validateChannels(tt.channels)
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("got error %v for %q, want it to be valid", err, tt.channels)
+		case !tt.valid && !errors.Is(err, ErrInvalidParams):
+			t.Errorf("got error %v for %q, want %v", err, tt.channels, ErrInvalidParams)
+		}
+	}
+}
+
+func TestRedundantPair(t *testing.T) {
+	newPair := func(t *testing.T, primaryState, backupState string) (*DSP, map[string]*atomic.Int32) {
+		cores := map[string]*fakeCore{"127.0.0.1": newFakeCore(t), "backup": newFakeCore(t)}
+		cores["127.0.0.1"].status.State = primaryState
+		cores["backup"].status.State = backupState
+
+		dials := map[string]*atomic.Int32{"127.0.0.1": {}, "backup": {}}
+		d := newFakeDSP(cores["127.0.0.1"], WithBackup("backup"), WithTransport(func(ctx context.Context, addr string) (Transport, error) {
+			dials[addr].Add(1)
+			return cores[addr].Dial(ctx, addr)
+		}))
+		t.Cleanup(d.Close)
+
+		return d, dials
+	}
+
+	t.Run("active primary", func(t *testing.T) {
+		d, dials := newPair(t, "Active", "Standby")
+		if _, err := d.EngineStatus(testContext(t)); err != nil {
+			t.Fatalf("unable to get engine status: %v", err)
+		}
+
+		if active := d.pair.Active(); active != "127.0.0.1" {
+			t.Errorf("connected to %s, want the primary", active)
+		}
+
+		if n := dials["backup"].Load(); n != 0 {
+			t.Errorf("dialed the backup %d times, want 0", n)
+		}
+	})
+
+	t.Run("active backup", func(t *testing.T) {
+		d, _ := newPair(t, "Standby", "Active")
+		status, err := d.EngineStatus(testContext(t))
+		if err != nil {
+			t.Fatalf("unable to get engine status: %v", err)
+		}
+
+		if active := d.pair.Active(); active != "backup" || status.State != "Active" {
+			t.Errorf("connected to %s in %s, want the backup in Active", active, status.State)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		d, _ := newPair(t, "Active", "Standby")
+		if _, err := d.EngineStatus(testContext(t)); err != nil {
+			t.Fatalf("unable to get engine status: %v", err)
+		}
+
+		d.Close()
+
+		select {
+		case <-d.pair.ctx.Done():
+		default:
+			t.Error("standby probe is still running")
+		}
+
+		if _, err := d.EngineStatus(testContext(t)); !errors.Is(err, ErrConnection) {
+			t.Errorf("got error %v, want %v", err, ErrConnection)
+		}
+	})
+}
+
+func TestDeviceManager(t *testing.T) {
+	dm := &DeviceManager{
+		Log:     zap.NewNop(),
+		DspList: &sync.Map{},
+		Config:  Config{DSPs: map[string]DSPConfig{"primary": {Backup: "backup"}}},
+	}
+
+	backup := dm.CreateDSP("backup")
+	if primary := dm.CreateDSP("primary"); primary != backup {
+		t.Error("got different DSPs for the primary and backup of a redundant pair")
+	}
+
+	if backup.pair == nil {
+		t.Fatal("got a DSP that isn't a redundant pair for the backup")
+	}
+
+	other := dm.CreateDSP("other")
+	if other == backup || other.pair != nil {
+		t.Error("got the redundant pair for an address that isn't part of it")
+	}
+
+	dm.Close()
+
+	select {
+	case <-backup.pair.ctx.Done():
+	default:
+		t.Error("standby probe is still running")
+	}
+
+	n := 0
+	dm.DspList.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+
+	if n != 0 {
+		t.Errorf("got %d DSPs after closing, want 0", n)
+	}
+
+	if _, err := other.Control(testContext(t), "Gain"); !errors.Is(err, ErrConnection) {
+		t.Errorf("got error %v, want %v", err, ErrConnection)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	serve := func(t *testing.T, core *fakeCore, greeting []byte) string {
+		srv := httptest.NewServer(core.ServeWebSocket(greeting))
+		t.Cleanup(srv.Close)
+
+		return strings.TrimPrefix(srv.URL, "http://")
+	}
+
+	t.Run("framing", func(t *testing.T) {
+		core := newFakeCore(t)
+		core.Handle("StatusGet", func(req fakeRequest) interface{} {
+			return core.status
+		})
+
+		greeting := []byte(`{"jsonrpc":"2.0","method":"ChangeGroup.Poll","params":{"Id":"test","Changes":[]}}`)
+		tr, err := dialWebSocket(false, nil)(testContext(t), serve(t, core, greeting))
+		if err != nil {
+			t.Fatalf("unable to dial: %v", err)
+		}
+		defer tr.Close()
+
+		prompt, err := tr.ReadFrame()
+		if err != nil {
+			t.Fatalf("unable to read prompt: %v", err)
+		}
+
+		if status, ok := parseEngineStatus(prompt); !ok || status != core.status {
+			t.Errorf("got prompt %s, want the engine status %+v", prompt, core.status)
+		}
+
+		// frames the core sent before the status are read after it
+		frame, err := tr.ReadFrame()
+		if err != nil || string(frame) != string(greeting) {
+			t.Errorf("got frame %s (%v), want %s", frame, err, greeting)
+		}
+
+		if err := tr.WriteFrame([]byte(`{"jsonrpc":"2.0","id":5,"method":"NoOp","params":{}}`), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("unable to write frame: %v", err)
+		}
+
+		frame, err = tr.ReadFrame()
+		if err != nil || string(frame) != `{"id":5,"jsonrpc":"2.0","result":true}` {
+			t.Errorf("got frame %s (%v), want the response to the NoOp", frame, err)
+		}
+	})
+
+	t.Run("logon", func(t *testing.T) {
+		core := newFakeCore(t)
+
+		var loggedOn atomic.Bool
+		core.Handle("StatusGet", func(req fakeRequest) interface{} {
+			if !loggedOn.Load() {
+				return &Error{Code: 10, Message: "Logon required"}
+			}
+
+			return core.status
+		})
+
+		core.Handle("Logon", func(req fakeRequest) interface{} {
+			var params QSCLogonParams
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.User != "admin" || params.Password != "1234" {
+				return &Error{Code: 10, Message: "Logon required"}
+			}
+
+			loggedOn.Store(true)
+			return true
+		})
+
+		core.Handle("Control.Get", func(req fakeRequest) interface{} {
+			if !loggedOn.Load() {
+				return &Error{Code: 10, Message: "Logon required"}
+			}
+
+			return controlValues(map[string]float64{"Gain": -10})(req)
+		})
+
+		d := newDSP(serve(t, core, nil), WithTransport(dialWebSocket(false, nil)), WithPollRate(0), WithKeepAlive(0), WithCredentials(StaticCredentials("admin", "1234")))
+		t.Cleanup(d.Close)
+
+		res, err := d.Control(testContext(t), "Gain")
+		if err != nil {
+			t.Fatalf("unable to get control: %v", err)
+		}
+
+		if res.Value != -10 {
+			t.Errorf("got value %v, want -10", res.Value)
+		}
+
+		if n := len(core.Requests("Logon")); n != 1 {
+			t.Errorf("got %d logons, want 1", n)
+		}
+	})
+}
+
+func TestEventBufferSince(t *testing.T) {
+	b := newEventBuffer()
+	for i := 0; i < _eventBufferSize+10; i++ {
+		b.publish(EventControl, QSCChange{Name: "Gain", Value: float64(i)})
+	}
+
+	events, _, ok := b.since(b.latest() - 5)
+	if !ok || len(events) != 5 {
+		t.Errorf("got %d events (ok %v) for the last 5, want 5", len(events), ok)
+	}
+
+	// the oldest buffered event is 11, so 10 is the oldest id that can be caught up from
+	if events, _, ok := b.since(10); !ok || len(events) != _eventBufferSize {
+		t.Errorf("got %d events (ok %v) after 10, want %d", len(events), ok, _eventBufferSize)
+	}
+
+	if events, _, ok := b.since(9); ok || len(events) != 0 {
+		t.Errorf("got %d events (ok %v) after 9, want none and not ok", len(events), ok)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{DSPs: map[string]DSPConfig{
+		"10.0.0.1": {TLS: &TLSConfig{InsecureSkipVerify: true}},
+	}}
+
+	if err := valid.Validate(); err != nil {
+		t.Errorf("got error %v for a valid config", err)
+	}
+
+	missingCA := Config{DSPs: map[string]DSPConfig{
+		"10.0.0.1": {TLS: &TLSConfig{CAFile: "does-not-exist.pem"}},
+	}}
+
+	if err := missingCA.Validate(); err == nil {
+		t.Error("got no error for a ca file that doesn't exist")
+	}
+
+	badCurve := Config{DSPs: map[string]DSPConfig{
+		"10.0.0.1": {Blocks: map[string]BlockConfig{
+			"ZoneGain": {Curve: &CurveConfig{Type: "log", MinDB: 0, MaxDB: -60}},
+		}},
+	}}
+
+	if err := badCurve.Validate(); err == nil {
+		t.Error("got no error for a curve with minDb above maxDb")
+	}
+}
+
+func TestECP(t *testing.T) {
+	newECP := func(t *testing.T, pin string) (*fakeECPCore, *DSP) {
+		core := newFakeECPCore(t, "1234")
+		core.Set("Gain", fakeECPControl{value: -10, position: 0.5, str: "-10.0dB"})
+		core.Set("Source", fakeECPControl{value: 1, str: "HDMI 1"})
+
+		d := newDSP("core", WithTransport(core.Dial), WithPollRate(10*time.Millisecond), WithKeepAlive(0),
+			WithVolumeMode("Gain", VolumeModePosition), WithCredentials(StaticCredentials("admin", pin)))
+		t.Cleanup(d.Close)
+
+		return core, d
+	}
+
+	t.Run("controls", func(t *testing.T) {
+		core, d := newECP(t, "1234")
+
+		res, err := d.Control(testContext(t), "Gain")
+		if err != nil {
+			t.Fatalf("unable to get control: %v", err)
+		}
+
+		want := QSCGetStatusResult{Name: "Gain", Value: -10, String: "-10.0dB", Position: 0.5}
+		if res != want {
+			t.Errorf("got %+v, want %+v", res, want)
+		}
+
+		if res, err := d.SetControl(testContext(t), "Gain", -20.0); err != nil || res.Value != -20 {
+			t.Errorf("got %+v (%v) after setting the value, want -20", res, err)
+		}
+
+		if res, err := d.SetControl(testContext(t), "Source", "HDMI 2"); err != nil || res.String != "HDMI 2" {
+			t.Errorf("got %+v (%v) after setting the string, want HDMI 2", res, err)
+		}
+
+		if err := d.SetVolume(testContext(t), "Gain", 40); err != nil {
+			t.Errorf("unable to set volume: %v", err)
+		}
+
+		var qscErr *Error
+		if _, err := d.Control(testContext(t), "Missing"); !errors.As(err, &qscErr) || qscErr.Code != 8 {
+			t.Errorf("got error %v for an unknown control, want an unknown control error", err)
+		}
+
+		status, err := d.EngineStatus(testContext(t))
+		if err != nil || status.DesignName != "Test Design" || status.State != "Active" {
+			t.Errorf("got engine status %+v (%v), want Test Design in Active", status, err)
+		}
+
+		commands := []string{
+			`login "admin" "1234"`,
+			`cg "Gain"`,
+			`csv "Gain" -20`,
+			`cg "Gain"`,
+			`css "Source" "HDMI 2"`,
+			`cg "Source"`,
+			`csp "Gain" 0.4`,
+			`cg "Gain"`,
+			`cg "Missing"`,
+		}
+
+		if got := core.Commands(); strings.Join(got, "\n") != strings.Join(commands, "\n") {
+			t.Errorf("got commands %q, want %q", got, commands)
+		}
+	})
+
+	t.Run("change group", func(t *testing.T) {
+		core, d := newECP(t, "1234")
+
+		sub := d.Subscribe()
+		defer sub.Close(testContext(t))
+
+		if err := sub.Add(testContext(t), []string{"Gain"}, nil); err != nil {
+			t.Fatalf("unable to subscribe: %v", err)
+		}
+
+		core.Set("Gain", fakeECPControl{value: -5, position: 0.7, str: "-5.0dB"})
+
+		ctx := testContext(t)
+		for {
+			changes, err := sub.Next(ctx)
+			if err != nil {
+				t.Fatalf("didn't get the change to Gain: %v", err)
+			}
+
+			if len(changes) == 1 && changes[0].Value == -5 {
+				break
+			}
+		}
+
+		commands := strings.Join(core.Commands(), "\n")
+		for _, cmd := range []string{"cgc 1", `cga 1 "Gain"`, "cgp 1"} {
+			if !strings.Contains(commands, cmd) {
+				t.Errorf("core never got %s, got %s", cmd, commands)
+			}
+		}
+	})
+
+	t.Run("bad logon", func(t *testing.T) {
+		core, d := newECP(t, "0000")
+
+		if _, err := d.Control(testContext(t), "Gain"); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("got error %v, want %v", err, ErrUnauthorized)
+		}
+
+		for _, cmd := range core.Commands() {
+			if cmd == `cg "Gain"` {
+				t.Error("core got a command before logging on")
+			}
+		}
+	})
+}
+
+func TestSplitECP(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: `sr "My Design" "abc123" 1 1`, want: []string{"sr", "My Design", "abc123", "1", "1"}},
+		{line: `cv "Zone Gain" "-10.0dB" -10 0.5`, want: []string{"cv", "Zone Gain", "-10.0dB", "-10", "0.5"}},
+		{line: `cv "Label" "say \"hi\"" 0 0`, want: []string{"cv", "Label", `say "hi"`, "0", "0"}},
+		{line: `cv "Path" "C:\\audio" 0 0`, want: []string{"cv", "Path", `C:\audio`, "0", "0"}},
+		{line: `cv "Empty" "" 0 0`, want: []string{"cv", "Empty", "", "0", "0"}},
+		{line: `cv  "Spaces"   "a  b"  1  1 `, want: []string{"cv", "Spaces", "a  b", "1", "1"}},
+		{line: `bad_id "Missing"`, want: []string{"bad_id", "Missing"}},
+		{line: ``, want: nil},
+	}
+
+	for _, tt := range tests {
+		got := splitECP(tt.line)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitECP(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+
+		// quoting every field and splitting it again gives back the same fields
+		if len(tt.want) > 0 {
+			quoted := make([]string, len(tt.want))
+			for i, field := range tt.want {
+				quoted[i] = ecpQuote(field)
+			}
+
+			if again := splitECP(strings.Join(quoted, " ")); strings.Join(again, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("quoted fields of %q split into %q", tt.line, again)
+			}
+		}
+	}
+}
+
+func TestParseECPValue(t *testing.T) {
+	tests := []struct {
+		line string
+		want QSCChange
+		ok   bool
+	}{
+		{line: `cv "Zone Gain" "-10.0dB" -10 0.5`, want: QSCChange{Name: "Zone Gain", String: "-10.0dB", Value: -10, Position: 0.5}, ok: true},
+		{line: `cv "Source" "HDMI \"2\"" 2 1`, want: QSCChange{Name: "Source", String: `HDMI "2"`, Value: 2, Position: 1}, ok: true},
+		{line: `cv "Mute" "unmuted" 0 0`, want: QSCChange{Name: "Mute", String: "unmuted", Value: 0, Position: 0}, ok: true},
+		{line: `cv "Short" "x" 1`},
+		{line: `cv "Value" "x" abc 0`},
+		{line: `cv "Position" "x" 1 abc`},
+		{line: `sr "My Design" "abc123" 1 1`},
+		{line: `bad_id "Zone Gain"`},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseECPValue(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseECPValue(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestECPStatus(t *testing.T) {
+	tests := []struct {
+		line  string
+		state string
+	}{
+		{line: `sr "My Design" "abc 123" 1 1`, state: "Active"},
+		{line: `sr "My Design" "abc 123" 0 0`, state: "Standby"},
+	}
+
+	for _, tt := range tests {
+		status := (&ecpTransport{status: tt.line}).engineStatus()
+		if status.DesignName != "My Design" || status.DesignCode != "abc 123" || status.State != tt.state {
+			t.Errorf("got status %+v for %q, want My Design (abc 123) %s", status, tt.line, tt.state)
+		}
+	}
+
+	if err := ecpError(`bad_id "Zone Gain"`); err == nil || err.Code != 8 {
+		t.Errorf("got error %v for bad_id, want an unknown control", err)
+	}
+
+	if err := ecpError(`cv "Zone Gain" "-10.0dB" -10 0.5`); err != nil {
+		t.Errorf("got error %v for a cv line", err)
+	}
+}
@@ -0,0 +1,170 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QSCGetComponentsRequest is for the Component.GetComponents method
+type QSCGetComponentsRequest struct {
+	BaseRequest
+	Params interface{} `json:"params"`
+}
+
+// QSCGetComponentsResponse is the response to the Component.GetComponents method
+type QSCGetComponentsResponse struct {
+	BaseResponse
+	Result []QSCComponent `json:"result"`
+}
+
+// QSCComponent is a named component in the running design
+type QSCComponent struct {
+	Name       string
+	Type       string
+	Properties []QSCComponentProperty
+}
+
+// QSCComponentProperty is a single property of a component, as set in Designer
+type QSCComponentProperty struct {
+	Name  string
+	Value string
+}
+
+// QSCGetControlsRequest is for the Component.GetControls method
+type QSCGetControlsRequest struct {
+	BaseRequest
+	Params QSCGetControlsParams `json:"params"`
+}
+
+// QSCGetControlsParams is the parameters for the Component.GetControls method
+type QSCGetControlsParams struct {
+	Name string
+}
+
+// QSCGetControlsResponse is the response to the Component.GetControls method
+type QSCGetControlsResponse struct {
+	BaseResponse
+	Result QSCGetControlsResult `json:"result"`
+}
+
+// QSCGetControlsResult is every control of a component
+type QSCGetControlsResult struct {
+	Name     string
+	Controls []QSCControl
+}
+
+// QSCControl is a control of a component and its current state
+type QSCControl struct {
+	Name      string
+	Type      string
+	Value     float64
+	ValueMin  float64
+	ValueMax  float64
+	String    string
+	StringMin string
+	StringMax string
+	Position  float64
+	Direction string
+}
+
+// UnmarshalJSON decodes a control the same way as a QSCGetStatusResult.
+func (c *QSCControl) UnmarshalJSON(data []byte) error {
+	type control QSCControl
+	return unmarshalControl(data, (*control)(c), &c.Value, &c.String, nil)
+}
+
+// GetGenericGetComponentsRequest is used to list every component in the design.
+// The core ignores the params of this method, but the QRC docs always send "test".
+func (d *DSP) GetGenericGetComponentsRequest(ctx context.Context) QSCGetComponentsRequest {
+	return QSCGetComponentsRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Component.GetComponents"}, Params: "test"}
+}
+
+func (d *DSP) GetGenericGetControlsRequest(ctx context.Context) QSCGetControlsRequest {
+	return QSCGetControlsRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "Component.GetControls"}, Params: QSCGetControlsParams{}}
+}
+
+func (dm *DeviceManager) HandlerGetComponents(ctx *gin.Context) {
+	addr := ctx.Param("address")
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("getting components", zap.String("address", addr))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	components, err := dsp.Components(c)
+	if err != nil {
+		dm.Log.Error("unable to get components", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got components", zap.String("address", addr), zap.Int("count", len(components)))
+	ctx.JSON(http.StatusOK, components)
+}
+
+func (dm *DeviceManager) HandlerGetComponentControls(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("getting component controls", zap.String("address", addr), zap.String("name", name))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	controls, err := dsp.ComponentControls(c, name)
+	if err != nil {
+		dm.Log.Error("unable to get component controls", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got component controls", zap.String("address", addr), zap.String("name", name), zap.Int("count", len(controls)))
+	ctx.JSON(http.StatusOK, controls)
+}
+
+// Components lists every named component in the running design.
+func (d *DSP) Components(ctx context.Context) ([]QSCComponent, error) {
+	req := d.GetGenericGetComponentsRequest(ctx)
+
+	d.log.Info("Getting components")
+
+	resp, err := d.client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	qscResp := QSCGetComponentsResponse{}
+	if err := json.Unmarshal(resp, &qscResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %w", err)
+	}
+
+	return qscResp.Result, nil
+}
+
+// ComponentControls lists every control of the named component.
+func (d *DSP) ComponentControls(ctx context.Context, component string) ([]QSCControl, error) {
+	req := d.GetGenericGetControlsRequest(ctx)
+	req.Params.Name = component
+
+	d.log.Info("Getting component controls", zap.String("component", component))
+
+	resp, err := d.client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	qscResp := QSCGetControlsResponse{}
+	if err := json.Unmarshal(resp, &qscResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %w", err)
+	}
+
+	return qscResp.Result.Controls, nil
+}
@@ -0,0 +1,606 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// _ecpChangeGroup is the handle of the only change group we create on an ECP connection.
+const _ecpChangeGroup = "1"
+
+// _ecpTimeout is how long the core has to answer a command.
+// The responses to every later command can't be told apart if it doesn't, so the connection is closed.
+const _ecpTimeout = 5 * time.Second
+
+// ecpTransport speaks the External Control Protocol on port 1702 and translates it to and from QRC frames,
+// so that the client, change group, keepalives, and logon work the same way they do over QRC.
+// Only named controls, change groups of named controls, and the engine status are supported;
+// every other method is answered with a method not found error.
+//
+// ECP doesn't have request ids, and most commands have no response unless they fail,
+// so requests are run one at a time and every one ends with sg. The sr line that answers it
+// marks the end of the request's responses, and also keeps the engine status up to date.
+type ecpTransport struct {
+	conn   net.Conn
+	lines  chan string
+	ops    chan frame
+	frames chan []byte
+
+	closeOnce sync.Once
+	err       error
+	done      chan struct{}
+
+	// only used by the goroutine running requests
+	status   string
+	group    bool
+	stopPoll chan struct{}
+}
+
+func dialECP(ctx context.Context, addr string) (Transport, error) {
+	dial := net.Dialer{}
+	conn, err := dial.DialContext(ctx, "tcp", addr+":1702")
+	if err != nil {
+		return nil, err
+	}
+
+	return openECP(conn)
+}
+
+// openECP starts speaking ECP on conn, which is closed if the engine status can't be read.
+func openECP(conn net.Conn) (Transport, error) {
+	t := &ecpTransport{
+		conn:   conn,
+		lines:  make(chan string, 64),
+		ops:    make(chan frame, 64),
+		frames: make(chan []byte, 64),
+		done:   make(chan struct{}),
+	}
+
+	go t.readLines()
+
+	// the core doesn't send anything when we connect, so ask for the engine status that QRC would have sent.
+	// cores that require a logon don't answer until we have logged on, so the status is sent after the logon instead.
+	_, err := t.exec()
+	var qscErr *Error
+	if err != nil && !errors.As(err, &qscErr) {
+		t.Close()
+		return nil, fmt.Errorf("unable to get engine status: %w", err)
+	}
+
+	if t.status == "" {
+		t.frames <- []byte{}
+	}
+
+	go t.run()
+	return t, nil
+}
+
+func (t *ecpTransport) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-t.frames:
+		return frame, nil
+	case <-t.done:
+		return nil, t.err
+	}
+}
+
+func (t *ecpTransport) WriteFrame(buf []byte, deadline time.Time) error {
+	var f frame
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return fmt.Errorf("unable to parse request: %w", err)
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case t.ops <- f:
+		return nil
+	case <-t.done:
+		return t.err
+	case <-timer.C:
+		return errors.New("timed out waiting for the requests before it")
+	}
+}
+
+func (t *ecpTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *ecpTransport) Close() error {
+	t.close(net.ErrClosed)
+	return nil
+}
+
+func (t *ecpTransport) close(err error) {
+	t.closeOnce.Do(func() {
+		t.err = err
+		close(t.done)
+		t.conn.Close()
+	})
+}
+
+// readLines sends every line the core sends to t.lines until the connection is closed.
+func (t *ecpTransport) readLines() {
+	reader := bufio.NewReader(t.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.close(err)
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		select {
+		case t.lines <- line:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// run answers each request in the order they were written until the connection is closed.
+func (t *ecpTransport) run() {
+	defer t.stopAutoPoll()
+
+	for {
+		var f frame
+		select {
+		case f = <-t.ops:
+		case <-t.done:
+			return
+		}
+
+		result, err := t.handle(f)
+
+		var qscErr *Error
+		switch {
+		case err != nil && !errors.As(err, &qscErr):
+			// the connection has been closed
+			return
+		case f.ID == nil:
+			// polls started by auto poll are sent to the change group as notifications
+			if poll, ok := result.(QSCChangeGroupPollResult); ok && len(poll.Changes) > 0 {
+				t.emit(map[string]interface{}{"jsonrpc": "2.0", "method": "ChangeGroup.Poll", "params": poll})
+			}
+		case qscErr != nil:
+			t.emit(map[string]interface{}{"jsonrpc": "2.0", "id": *f.ID, "error": qscErr})
+		default:
+			t.emit(map[string]interface{}{"jsonrpc": "2.0", "id": *f.ID, "result": result})
+		}
+	}
+}
+
+// handle runs the ECP commands for a single QRC request and returns its result.
+// Errors that aren't an *Error mean that the connection has been closed.
+func (t *ecpTransport) handle(f frame) (interface{}, error) {
+	switch f.Method {
+	case "NoOp":
+		_, err := t.exec()
+		return struct{}{}, err
+	case "StatusGet":
+		if _, err := t.exec(); err != nil {
+			return nil, err
+		}
+
+		return t.engineStatus(), nil
+	case "Logon":
+		var params QSCLogonParams
+		if err := json.Unmarshal(f.Params, &params); err != nil {
+			return nil, ecpInvalidParams(err)
+		}
+
+		resp, err := t.exec("login " + ecpQuote(params.User) + " " + ecpQuote(params.Password))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range resp {
+			if line == "login_failed" {
+				return nil, &Error{Code: 10, Message: "Logon failed"}
+			}
+		}
+
+		return true, nil
+	case "Control.Get":
+		var names []string
+		if err := json.Unmarshal(f.Params, &names); err != nil {
+			return nil, ecpInvalidParams(err)
+		}
+
+		return t.controlGet(names)
+	case "Control.Set":
+		var params QSCSetStatusParams
+		if err := json.Unmarshal(f.Params, &params); err != nil {
+			return nil, ecpInvalidParams(err)
+		}
+
+		return t.controlSet(params)
+	case "ChangeGroup.AddControl", "ChangeGroup.Remove", "ChangeGroup.Poll", "ChangeGroup.Invalidate", "ChangeGroup.Destroy", "ChangeGroup.AutoPoll":
+		var params QSCChangeGroupParams
+		if len(f.Params) > 0 {
+			if err := json.Unmarshal(f.Params, &params); err != nil {
+				return nil, ecpInvalidParams(err)
+			}
+		}
+
+		return t.changeGroup(f.Method, params)
+	default:
+		return nil, &Error{Code: -32601, Message: fmt.Sprintf("%s is not supported over ECP", f.Method)}
+	}
+}
+
+func (t *ecpTransport) controlGet(names []string) ([]QSCGetStatusResult, error) {
+	cmds := make([]string, len(names))
+	for i, name := range names {
+		cmds[i] = "cg " + ecpQuote(name)
+	}
+
+	resp, err := t.exec(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]QSCGetStatusResult, 0, len(names))
+	for _, line := range resp {
+		if err := ecpError(line); err != nil {
+			return nil, err
+		}
+
+		change, ok := parseECPValue(line)
+		if !ok {
+			continue
+		}
+
+		results = append(results, QSCGetStatusResult{Name: change.Name, Value: change.Value, String: change.String, Position: change.Position})
+	}
+
+	if len(results) != len(names) {
+		return nil, &Error{Code: -32602, Message: fmt.Sprintf("got %d values for %d controls", len(results), len(names))}
+	}
+
+	return results, nil
+}
+
+// controlSet sets a control and then reads it back, since ECP doesn't answer successful sets.
+func (t *ecpTransport) controlSet(params QSCSetStatusParams) (QSCGetStatusResult, error) {
+	name := ecpQuote(params.Name)
+	ramp := ecpNumber(params.Ramp)
+
+	var cmd string
+	switch {
+	case params.Position != nil && params.Ramp > 0:
+		cmd = "cspr " + name + " " + ecpNumber(*params.Position) + " " + ramp
+	case params.Position != nil:
+		cmd = "csp " + name + " " + ecpNumber(*params.Position)
+	default:
+		switch value := params.Value.(type) {
+		case bool:
+			n := 0.0
+			if value {
+				n = 1
+			}
+
+			cmd = "csv " + name + " " + ecpNumber(n)
+		case float64:
+			cmd = "csv " + name + " " + ecpNumber(value)
+			if params.Ramp > 0 {
+				cmd = "csvr " + name + " " + ecpNumber(value) + " " + ramp
+			}
+		case string:
+			cmd = "css " + name + " " + ecpQuote(value)
+		default:
+			return QSCGetStatusResult{}, &Error{Code: -32602, Message: fmt.Sprintf("unsupported value %v", value)}
+		}
+	}
+
+	resp, err := t.exec(cmd)
+	if err != nil {
+		return QSCGetStatusResult{}, err
+	}
+
+	for _, line := range resp {
+		if err := ecpError(line); err != nil {
+			return QSCGetStatusResult{}, err
+		}
+	}
+
+	results, err := t.controlGet([]string{params.Name})
+	if err != nil {
+		return QSCGetStatusResult{}, err
+	}
+
+	return results[0], nil
+}
+
+func (t *ecpTransport) changeGroup(method string, params QSCChangeGroupParams) (interface{}, error) {
+	var cmds []string
+	switch method {
+	case "ChangeGroup.AddControl":
+		if !t.group {
+			cmds = append(cmds, "cgc "+_ecpChangeGroup)
+			t.group = true
+		}
+
+		for _, name := range params.Controls {
+			cmds = append(cmds, "cga "+_ecpChangeGroup+" "+ecpQuote(name))
+		}
+	case "ChangeGroup.AutoPoll":
+		if params.Rate <= 0 {
+			return nil, &Error{Code: -32602, Message: "auto poll rate must be positive"}
+		}
+
+		t.stopAutoPoll()
+		t.stopPoll = make(chan struct{})
+		go t.autoPoll(time.Duration(params.Rate*float64(time.Second)), t.stopPoll)
+		return true, nil
+	case "ChangeGroup.Destroy":
+		t.stopAutoPoll()
+		if !t.group {
+			return true, nil
+		}
+
+		cmds = append(cmds, "cgd "+_ecpChangeGroup)
+		t.group = false
+	case "ChangeGroup.Poll":
+		if !t.group {
+			return QSCChangeGroupPollResult{ID: params.ID}, nil
+		}
+
+		cmds = append(cmds, "cgp "+_ecpChangeGroup)
+	case "ChangeGroup.Remove", "ChangeGroup.Invalidate":
+		if !t.group {
+			return true, nil
+		}
+
+		if method == "ChangeGroup.Invalidate" {
+			cmds = append(cmds, "cgi "+_ecpChangeGroup)
+		}
+
+		for _, name := range params.Controls {
+			cmds = append(cmds, "cgr "+_ecpChangeGroup+" "+ecpQuote(name))
+		}
+	}
+
+	resp, err := t.exec(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := QSCChangeGroupPollResult{ID: params.ID}
+	for _, line := range resp {
+		if err := ecpError(line); err != nil {
+			return nil, err
+		}
+
+		if change, ok := parseECPValue(line); ok {
+			result.Changes = append(result.Changes, change)
+		}
+	}
+
+	if method == "ChangeGroup.Poll" {
+		return result, nil
+	}
+
+	return true, nil
+}
+
+// autoPoll polls the change group every rate until stop is closed, skipping polls while other requests are waiting.
+func (t *ecpTransport) autoPoll(rate time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(rate)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-t.done:
+			return
+		}
+
+		poll, _ := json.Marshal(QSCChangeGroupParams{ID: _changeGroupID})
+		select {
+		case t.ops <- frame{Method: "ChangeGroup.Poll", Params: poll}:
+		default:
+		}
+	}
+}
+
+func (t *ecpTransport) stopAutoPoll() {
+	if t.stopPoll != nil {
+		close(t.stopPoll)
+		t.stopPoll = nil
+	}
+}
+
+// exec sends cmds followed by sg, and returns every line the core sends before the sr that answers sg.
+func (t *ecpTransport) exec(cmds ...string) ([]string, error) {
+	var buf strings.Builder
+	for _, cmd := range cmds {
+		buf.WriteString(cmd + "\n")
+	}
+	buf.WriteString("sg\n")
+
+	t.conn.SetWriteDeadline(time.Now().Add(_ecpTimeout))
+	if _, err := io.WriteString(t.conn, buf.String()); err != nil {
+		t.close(err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(_ecpTimeout)
+	defer timer.Stop()
+
+	// every command except login, including sg, is answered with login_required until we have logged on
+	expected := 1
+	for _, cmd := range cmds {
+		if !strings.HasPrefix(cmd, "login ") {
+			expected++
+		}
+	}
+
+	var resp []string
+	denied := 0
+	for {
+		select {
+		case line := <-t.lines:
+			switch {
+			case strings.HasPrefix(line, "sr "):
+				t.setStatus(line)
+				return resp, nil
+			case line == "login_required":
+				denied++
+				if denied == expected {
+					return nil, &Error{Code: 10, Message: "Logon required"}
+				}
+			default:
+				resp = append(resp, line)
+			}
+		case <-timer.C:
+			err := errors.New("timed out waiting for the core to respond")
+			t.close(err)
+			return nil, err
+		case <-t.done:
+			return nil, t.err
+		}
+	}
+}
+
+// setStatus keeps the latest sr line, and sends an EngineStatus notification whenever it changes.
+func (t *ecpTransport) setStatus(line string) {
+	if line == t.status {
+		return
+	}
+
+	t.status = line
+	t.emit(QSCStatusReport{JSONRPC: "2.0", Method: "EngineStatus", Params: t.engineStatus()})
+}
+
+// engineStatus converts the latest sr line, which is sr "design name" "design code" is_primary is_active.
+func (t *ecpTransport) engineStatus() QSCStatusGetResult {
+	var status QSCStatusGetResult
+
+	fields := splitECP(t.status)
+	if len(fields) < 5 {
+		return status
+	}
+
+	status.DesignName = fields[1]
+	status.DesignCode = fields[2]
+	status.State = "Standby"
+	if fields[4] == "1" {
+		status.State = "Active"
+	}
+
+	status.Status.String = "OK"
+	return status
+}
+
+func (t *ecpTransport) emit(v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	select {
+	case t.frames <- buf:
+	case <-t.done:
+	}
+}
+
+// ecpError returns the error the core sent in line, or nil if line isn't an error.
+func ecpError(line string) *Error {
+	fields := splitECP(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch fields[0] {
+	case "bad_id":
+		return &Error{Code: 8, Message: "Unknown control " + strings.Join(fields[1:], " ")}
+	case "bad_command", "bad_change_group_handle":
+		return &Error{Code: -32602, Message: line}
+	default:
+		return nil
+	}
+}
+
+func ecpInvalidParams(err error) *Error {
+	return &Error{Code: -32602, Message: err.Error()}
+}
+
+// parseECPValue parses a cv line, which is cv "name" "string" value position.
+func parseECPValue(line string) (QSCChange, bool) {
+	fields := splitECP(line)
+	if len(fields) < 5 || fields[0] != "cv" {
+		return QSCChange{}, false
+	}
+
+	value, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return QSCChange{}, false
+	}
+
+	position, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return QSCChange{}, false
+	}
+
+	return QSCChange{Name: fields[1], String: fields[2], Value: value, Position: position}, true
+}
+
+// splitECP splits line on spaces, keeping quoted fields together and unescaping them.
+func splitECP(line string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case r == ' ' && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+func ecpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func ecpNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,74 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (dm *DeviceManager) HandlerGetEngineStatus(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	dm.Log.Debug("getting engine status", zap.String("address", addr))
+	dsp := dm.CreateDSP(addr)
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := dsp.EngineStatus(c)
+	if err != nil {
+		dm.Log.Error("unable to get engine status", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got engine status", zap.String("address", addr), zap.String("status", fmt.Sprintf("%+v", status)))
+	ctx.JSON(http.StatusOK, status)
+}
+
+// EngineStatus returns the latest EngineStatus the core has sent us.
+// The core sends its status as soon as we connect, so a connection is opened if there isn't one already;
+// no StatusGet request is sent. Cores that require a logon may not have sent one, which is an ErrConnection.
+func (d *DSP) EngineStatus(ctx context.Context) (QSCStatusGetResult, error) {
+	if _, err := d.client.connection(ctx); err != nil {
+		return QSCStatusGetResult{}, err
+	}
+
+	d.engineMu.RLock()
+	defer d.engineMu.RUnlock()
+
+	if d.engine == nil {
+		return QSCStatusGetResult{}, fmt.Errorf("%w: the core has not sent an engine status", ErrConnection)
+	}
+
+	return *d.engine, nil
+}
+
+// handleNotification is called for every notification the core sends.
+func (d *DSP) handleNotification(method string, params json.RawMessage) {
+	switch method {
+	case "EngineStatus":
+		var status QSCStatusGetResult
+		if err := json.Unmarshal(params, &status); err != nil {
+			d.log.Warn("unable to parse engine status", zap.ByteString("params", params), zap.Error(err))
+			return
+		}
+
+		d.log.Info("Got engine status", zap.String("state", status.State), zap.String("design", status.DesignName))
+
+		d.engineMu.Lock()
+		changed := d.engine == nil || *d.engine != status
+		d.engine = &status
+		d.engineMu.Unlock()
+
+		if changed {
+			d.events.publish(EventEngine, status)
+		}
+	default:
+		d.log.Debug("ignoring notification", zap.String("method", method))
+	}
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrInvalidControl is returned when the core doesn't know the requested control or component.
+	ErrInvalidControl = errors.New("invalid control name")
+
+	// ErrInvalidParams is returned when the core rejects the parameters of a request.
+	ErrInvalidParams = errors.New("invalid params")
+
+	// ErrUnauthorized is returned when the core rejects our credentials, or requires a logon that we didn't send.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrTimeout is returned when the core doesn't respond before the request's deadline.
+	ErrTimeout = errors.New("timed out waiting for the core")
+
+	// ErrConnection is returned when we are unable to connect or talk to the core.
+	ErrConnection = errors.New("unable to communicate with the core")
+
+	// ErrUnsupported is returned when the core, or the protocol used to talk to it, doesn't support a method.
+	ErrUnsupported = errors.New("not supported")
+)
+
+// Error is the JSON-RPC error object the core returns when a request fails.
+// It unwraps to the matching Err* value, so callers can use errors.Is to check what went wrong.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("core returned error %d: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case 7, 8: // unknown component name, unknown control
+		return ErrInvalidControl
+	case -32602, 9: // invalid params, illegal mixer channel index
+		return ErrInvalidParams
+	case 10: // logon required
+		return ErrUnauthorized
+	case -32601: // method not found
+		return ErrUnsupported
+	default:
+		return nil
+	}
+}
+
+// ErrorResponse is the body of every error response from the http server.
+type ErrorResponse struct {
+	Error string `json:"error"`
+
+	// Code is the error code returned by the core, if there was one.
+	Code int `json:"code,omitempty"`
+}
+
+// httpStatus returns the http status code that best describes err.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrInvalidControl):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidParams):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrConnection):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// newErrorResponse describes err, with the core's error code if there was one.
+func newErrorResponse(err error) ErrorResponse {
+	resp := ErrorResponse{
+		Error: err.Error(),
+	}
+
+	var qscErr *Error
+	if errors.As(err, &qscErr) {
+		resp.Code = qscErr.Code
+	}
+
+	return resp
+}
+
+// writeError responds to ctx with err and the http status code that best describes it.
+func writeError(ctx *gin.Context, err error) {
+	ctx.JSON(httpStatus(err), newErrorResponse(err))
+}
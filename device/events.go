@@ -0,0 +1,297 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// The types of events a DSP publishes
+const (
+	EventControl    = "control"
+	EventEngine     = "engine"
+	EventConnection = "connection"
+
+	// EventReset is only sent on event streams, to clients resuming from an event that can't be resumed from
+	// and to clients that fall too far behind. The client has missed events, so it should read the state it cares about again.
+	EventReset = "reset"
+)
+
+// _eventBufferSize is how many events are kept for clients resuming with Last-Event-ID.
+const _eventBufferSize = 256
+
+// Event is a change in the state or health of a DSP.
+// Data is a QSCChange for control events, a QSCStatusGetResult for engine events, and a ConnectionEvent for connection events.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// ConnectionEvent is the data of a connection event.
+type ConnectionEvent struct {
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ResetEvent is the data of a reset event.
+type ResetEvent struct {
+	Reason string `json:"reason"`
+}
+
+// eventBuffer keeps the latest events so that clients can catch up on the ones they missed.
+type eventBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	lastID uint64
+
+	// epoch is different every time a buffer is created, so that ids from before a restart aren't mistaken for new ones
+	epoch string
+
+	// published is closed and replaced every time an event is published
+	published chan struct{}
+}
+
+func newEventBuffer() *eventBuffer {
+	return &eventBuffer{
+		published: make(chan struct{}),
+		epoch:     strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+func (b *eventBuffer) publish(typ string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	b.events = append(b.events, Event{
+		ID:   b.lastID,
+		Type: typ,
+		Time: time.Now(),
+		Data: data,
+	})
+
+	if len(b.events) > _eventBufferSize {
+		b.events = append(b.events[:0], b.events[len(b.events)-_eventBufferSize:]...)
+	}
+
+	close(b.published)
+	b.published = make(chan struct{})
+}
+
+// since returns the buffered events after id, and a channel that is closed when the next event is published.
+// ok is false if some of the events after id are no longer buffered; no events are returned then.
+func (b *eventBuffer) since(id uint64) ([]Event, <-chan struct{}, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) > 0 && id < b.events[0].ID-1 {
+		return nil, b.published, false
+	}
+
+	var events []Event
+	for _, event := range b.events {
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+
+	return events, b.published, true
+}
+
+func (b *eventBuffer) latest() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastID
+}
+
+// eventID is the id of an event on an event stream, which is the buffer's epoch and the event's id.
+func (b *eventBuffer) eventID(id uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// resume returns the id to send events after for a client whose last event was lastEventID.
+// ok is false if the client can't resume without missing events, because the id is from before a restart,
+// is newer than any event, or is older than every buffered event; the id returned is then the latest one.
+func (b *eventBuffer) resume(lastEventID string) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	epoch, seq, found := strings.Cut(lastEventID, "-")
+	if !found || epoch != b.epoch {
+		return b.lastID, false
+	}
+
+	id, err := strconv.ParseUint(seq, 10, 64)
+	switch {
+	case err != nil, id > b.lastID:
+		return b.lastID, false
+	case len(b.events) > 0 && id < b.events[0].ID-1:
+		return b.lastID, false
+	}
+
+	return id, true
+}
+
+func (dm *DeviceManager) HandlerEvents(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	dsp := dm.CreateDSP(addr)
+
+	lastID := dsp.events.latest()
+	reset := false
+	if header := ctx.GetHeader("Last-Event-ID"); header != "" {
+		var ok bool
+		lastID, ok = dsp.events.resume(header)
+		reset = !ok
+	}
+
+	var controls []string
+	filter := make(map[string]bool)
+	for _, c := range ctx.QueryArray("controls") {
+		for _, name := range strings.Split(c, ",") {
+			if name != "" && !filter[name] {
+				controls = append(controls, name)
+				filter[name] = true
+			}
+		}
+	}
+
+	sub := dsp.Subscribe()
+	defer func() {
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer closeCancel()
+
+		if err := sub.Close(closeCtx); err != nil {
+			dm.Log.Warn("unable to close subscription", zap.String("address", addr), zap.Error(err))
+		}
+	}()
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if len(controls) > 0 {
+		if err := sub.Add(c, controls, nil); err != nil {
+			dm.Log.Error("unable to subscribe to controls", zap.String("address", addr), zap.Error(err))
+			writeError(ctx, err)
+			return
+		}
+	}
+
+	// connect, so that the connection and engine status are known
+	if _, err := dsp.EngineStatus(c); err != nil {
+		dm.Log.Warn("unable to get engine status", zap.String("address", addr), zap.Error(err))
+	}
+
+	dm.Log.Info("event stream opened", zap.String("address", addr), zap.Uint64("lastEventID", lastID))
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+
+	if reset {
+		dm.Log.Info("event stream can't be resumed, resetting", zap.String("address", addr), zap.String("lastEventID", ctx.GetHeader("Last-Event-ID")))
+
+		if err := writeReset(ctx.Writer, dsp.events.eventID(lastID), "events since the last event id are no longer available"); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		events, published, ok := dsp.events.since(lastID)
+		if !ok {
+			dm.Log.Info("event stream fell behind, resetting", zap.String("address", addr), zap.Uint64("lastEventID", lastID))
+
+			lastID = dsp.events.latest()
+			if err := writeReset(ctx.Writer, dsp.events.eventID(lastID), "the client fell too far behind and missed events"); err != nil {
+				return
+			}
+		}
+
+		for _, event := range events {
+			lastID = event.ID
+
+			// only the controls the client asked for are sent, not every control other clients are watching
+			if change, ok := event.Data.(QSCChange); ok && len(filter) > 0 && (change.Component != "" || !filter[change.Name]) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				dm.Log.Warn("unable to marshal event", zap.Error(err))
+				continue
+			}
+
+			if _, err := fmt.Fprintf(ctx.Writer, "id: %s\nevent: %s\ndata: %s\n\n", dsp.events.eventID(event.ID), event.Type, data); err != nil {
+				return
+			}
+		}
+
+		ctx.Writer.Flush()
+
+		select {
+		case <-published:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(ctx.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Request.Context().Done():
+			dm.Log.Info("event stream closed", zap.String("address", addr))
+			return
+		}
+	}
+}
+
+// Events returns the buffered events after id, and a channel that is closed when the next event is published.
+// An id of 0 returns every buffered event. ok is false if some of the events after id are no longer buffered.
+func (d *DSP) Events(id uint64) ([]Event, <-chan struct{}, bool) {
+	return d.events.since(id)
+}
+
+// writeReset writes a reset event to an event stream.
+// The reset has the id of the latest event, so that the client can resume from it if it reconnects.
+func writeReset(w io.Writer, id, reason string) error {
+	data, err := json.Marshal(Event{Type: EventReset, Time: time.Now(), Data: ResetEvent{Reason: reason}})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, EventReset, data)
+	return err
+}
+
+// connectionChanged publishes a connection event whenever the connection commands are sent on opens or fails.
+func (d *DSP) connectionChanged(up bool, err error) {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+
+	if d.connected != nil && *d.connected == up {
+		return
+	}
+
+	d.connected = &up
+
+	event := ConnectionEvent{
+		Connected: up,
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	d.events.publish(EventConnection, event)
+}
@@ -0,0 +1,518 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeRequest is a request a fakeCore received.
+type fakeRequest struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// fakeHandler scripts the response to a request. It returns the result to send, an *Error to send
+// as the error, fakeRaw to send a frame exactly as given, or fakeNoResponse to never respond.
+type fakeHandler func(req fakeRequest) interface{}
+
+// fakeRaw is sent to the client as is, instead of being wrapped in a response.
+type fakeRaw []byte
+
+// fakeSilence makes a fakeCore ignore a request.
+type fakeSilence struct{}
+
+// fakeNoResponse makes a fakeCore ignore a request.
+var fakeNoResponse = fakeSilence{}
+
+// fakeCore is an in-memory core that answers requests with scripted handlers.
+// Methods without a handler succeed with a result of true.
+type fakeCore struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	status   QSCStatusGetResult
+	handlers map[string]fakeHandler
+
+	// noStatus makes the core send an empty prompt instead of its status when a connection opens
+	noStatus bool
+	requests []fakeRequest
+}
+
+func newFakeCore(t *testing.T) *fakeCore {
+	core := &fakeCore{
+		t:        t,
+		handlers: make(map[string]fakeHandler),
+	}
+
+	core.status.Platform = "Core 110f"
+	core.status.State = "Active"
+	core.status.DesignName = "Test Design"
+	core.status.Status.String = "OK"
+
+	return core
+}
+
+// newFakeDSP returns a DSP connected to core. The change group is disabled
+// so that every read is sent to the core.
+func newFakeDSP(core *fakeCore, opts ...Option) *DSP {
+	opts = append([]Option{WithTransport(core.Dial), WithPollRate(0), WithKeepAlive(0)}, opts...)
+	return newDSP("127.0.0.1", opts...)
+}
+
+// Handle scripts the response to every request for method.
+func (c *fakeCore) Handle(method string, handler fakeHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[method] = handler
+}
+
+// Requests returns every request the core has received for method.
+func (c *fakeCore) Requests(method string) []fakeRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reqs []fakeRequest
+	for _, req := range c.requests {
+		if req.Method == method {
+			reqs = append(reqs, req)
+		}
+	}
+
+	return reqs
+}
+
+// Dial opens an in-memory connection to the core.
+func (c *fakeCore) Dial(ctx context.Context, addr string) (Transport, error) {
+	c.mu.Lock()
+	status, noStatus := c.status, c.noStatus
+	c.mu.Unlock()
+
+	conn := &fakeConn{
+		core:   c,
+		frames: make(chan []byte, 16),
+		done:   make(chan struct{}),
+	}
+
+	prompt, err := json.Marshal(QSCStatusReport{JSONRPC: "2.0", Method: "EngineStatus", Params: status})
+	if err != nil {
+		return nil, err
+	}
+
+	if noStatus {
+		prompt = []byte{}
+	}
+
+	conn.frames <- prompt
+	return conn, nil
+}
+
+// respond returns the frame that answers buf, or nil if it shouldn't be answered.
+func (c *fakeCore) respond(buf []byte) []byte {
+	var req fakeRequest
+	if err := json.Unmarshal(buf, &req); err != nil {
+		c.t.Errorf("unable to parse request %s: %v", buf, err)
+		return nil
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	handler, ok := c.handlers[req.Method]
+	c.mu.Unlock()
+
+	var result interface{} = true
+	if ok {
+		result = handler(req)
+	}
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+
+	var qscErr *Error
+	switch res := result.(type) {
+	case fakeRaw:
+		return res
+	case fakeSilence:
+		return nil
+	case error:
+		if !errors.As(res, &qscErr) {
+			c.t.Errorf("handler for %s returned %v, which isn't an *Error", req.Method, res)
+			return nil
+		}
+
+		resp["error"] = qscErr
+	default:
+		resp["result"] = res
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		c.t.Errorf("unable to marshal response to %s: %v", req.Method, err)
+		return nil
+	}
+
+	return out
+}
+
+// fakeConn is a single in-memory connection to a fakeCore.
+type fakeConn struct {
+	core   *fakeCore
+	frames chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (f *fakeConn) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-f.frames:
+		return frame, nil
+	case <-f.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (f *fakeConn) WriteFrame(frame []byte, deadline time.Time) error {
+	select {
+	case <-f.done:
+		return net.ErrClosed
+	default:
+	}
+
+	resp := f.core.respond(frame)
+	if resp == nil {
+		return nil
+	}
+
+	select {
+	case f.frames <- resp:
+		return nil
+	case <-f.done:
+		return net.ErrClosed
+	}
+}
+
+func (f *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1710}
+}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+
+	return nil
+}
+
+// controlValues answers Control.Get with the value of each requested control in values.
+// Controls that aren't in values are left out of the result, the way the core leaves out unknown controls.
+func controlValues(values map[string]float64) fakeHandler {
+	return func(req fakeRequest) interface{} {
+		var names []string
+		if err := json.Unmarshal(req.Params, &names); err != nil {
+			return &Error{Code: -32602, Message: err.Error()}
+		}
+
+		var results []QSCGetStatusResult
+		for _, name := range names {
+			if value, ok := values[name]; ok {
+				results = append(results, QSCGetStatusResult{Name: name, Value: value})
+			}
+		}
+
+		return results
+	}
+}
+
+// echoSet answers Control.Set with the name and value that were sent.
+func echoSet(req fakeRequest) interface{} {
+	var params QSCSetStatusParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return &Error{Code: -32602, Message: err.Error()}
+	}
+
+	result := map[string]interface{}{"Name": params.Name, "Value": params.Value}
+	if params.Position != nil {
+		result["Position"] = *params.Position
+	}
+
+	return result
+}
+
+// setParams returns the params of every Control.Set the core has received.
+func (c *fakeCore) setParams() []QSCSetStatusParams {
+	var params []QSCSetStatusParams
+	for _, req := range c.Requests("Control.Set") {
+		var p QSCSetStatusParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			c.t.Fatalf("unable to parse Control.Set params %s: %v", req.Params, err)
+		}
+
+		params = append(params, p)
+	}
+
+	return params
+}
+
+// fakeControls answers Control.Get and Control.Set from a shared set of values,
+// so that every get sees the sets before it. Gets take delay, which gives requests that aren't serialized time to overlap.
+// It returns a function that reads a value.
+func (c *fakeCore) fakeControls(values map[string]float64, delay time.Duration) func(name string) float64 {
+	var mu sync.Mutex
+	get := controlValues(values)
+
+	c.Handle("Control.Get", func(req fakeRequest) interface{} {
+		time.Sleep(delay)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		return get(req)
+	})
+
+	c.Handle("Control.Set", func(req fakeRequest) interface{} {
+		var params struct {
+			Name  string
+			Value float64
+		}
+
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return &Error{Code: -32602, Message: err.Error()}
+		}
+
+		mu.Lock()
+		values[params.Name] = params.Value
+		mu.Unlock()
+
+		return map[string]interface{}{"Name": params.Name, "Value": params.Value}
+	})
+
+	return func(name string) float64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return values[name]
+	}
+}
+
+// ServeWebSocket serves the core over QRC WebSocket, sending greeting as soon as the connection opens if it isn't nil.
+func (c *fakeCore) ServeWebSocket(greeting []byte) http.Handler {
+	upgrader := websocket.Upgrader{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != _qrcWebSocketPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			c.t.Errorf("unable to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if greeting != nil {
+			if err := conn.WriteMessage(websocket.TextMessage, greeting); err != nil {
+				return
+			}
+		}
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			resp := c.respond(msg)
+			if resp == nil {
+				continue
+			}
+
+			if err := conn.WriteMessage(websocket.TextMessage, resp); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// fakeECPControl is the state of a named control on a fakeECPCore.
+type fakeECPControl struct {
+	value, position float64
+	str             string
+}
+
+// fakeECPCore is a scripted core that speaks ECP. Every command needs a logon first if pin isn't empty.
+type fakeECPCore struct {
+	t   *testing.T
+	pin string
+
+	mu       sync.Mutex
+	controls map[string]fakeECPControl
+	group    map[string]bool
+	changed  map[string]bool
+	commands []string
+}
+
+func newFakeECPCore(t *testing.T, pin string) *fakeECPCore {
+	return &fakeECPCore{
+		t:        t,
+		pin:      pin,
+		controls: make(map[string]fakeECPControl),
+		changed:  make(map[string]bool),
+	}
+}
+
+// Dial opens an in-memory ECP connection to the core.
+func (c *fakeECPCore) Dial(ctx context.Context, addr string) (Transport, error) {
+	client, server := net.Pipe()
+	go c.serve(server)
+
+	return openECP(client)
+}
+
+// Set changes a control as if it had been changed on the core.
+func (c *fakeECPCore) Set(name string, control fakeECPControl) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.controls[name] = control
+	c.changed[name] = true
+}
+
+// Commands returns every command the core has received, except for sg.
+func (c *fakeECPCore) Commands() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.commands...)
+}
+
+func (c *fakeECPCore) serve(conn net.Conn) {
+	defer conn.Close()
+
+	loggedOn := c.pin == ""
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := splitECP(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var resp []string
+		switch {
+		case fields[0] == "login":
+			if len(fields) == 3 && fields[2] == c.pin {
+				loggedOn = true
+				resp = append(resp, "login_success")
+			} else {
+				resp = append(resp, "login_failed")
+			}
+		case !loggedOn:
+			resp = append(resp, "login_required")
+		default:
+			resp = c.exec(fields)
+		}
+
+		if fields[0] != "sg" {
+			c.mu.Lock()
+			c.commands = append(c.commands, scanner.Text())
+			c.mu.Unlock()
+		}
+
+		for _, line := range resp {
+			if _, err := io.WriteString(conn, line+"\r\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs a command from a client that has logged on, and returns the lines that answer it.
+func (c *fakeECPCore) exec(fields []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value := func(name string) string {
+		control, ok := c.controls[name]
+		if !ok {
+			return "bad_id " + ecpQuote(name)
+		}
+
+		return "cv " + ecpQuote(name) + " " + ecpQuote(control.str) + " " + ecpNumber(control.value) + " " + ecpNumber(control.position)
+	}
+
+	set := func(name string, change func(*fakeECPControl)) []string {
+		control, ok := c.controls[name]
+		if !ok {
+			return []string{"bad_id " + ecpQuote(name)}
+		}
+
+		change(&control)
+		c.controls[name] = control
+		c.changed[name] = true
+		return nil
+	}
+
+	number := func(s string) float64 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			c.t.Errorf("unable to parse number %q: %v", s, err)
+		}
+
+		return f
+	}
+
+	switch {
+	case fields[0] == "sg":
+		return []string{`sr "Test Design" "abc123" 1 1`}
+	case fields[0] == "cg" && len(fields) == 2:
+		return []string{value(fields[1])}
+	case fields[0] == "csv" && len(fields) == 3:
+		return set(fields[1], func(control *fakeECPControl) {
+			control.value = number(fields[2])
+			control.str = fields[2]
+		})
+	case fields[0] == "css" && len(fields) == 3:
+		return set(fields[1], func(control *fakeECPControl) {
+			control.str = fields[2]
+		})
+	case fields[0] == "csp" && len(fields) == 3:
+		return set(fields[1], func(control *fakeECPControl) {
+			control.position = number(fields[2])
+		})
+	case fields[0] == "cgc" && len(fields) == 2:
+		c.group = make(map[string]bool)
+		return nil
+	case fields[0] == "cga" && len(fields) == 3 && c.group != nil:
+		c.group[fields[2]] = true
+		c.changed[fields[2]] = true
+		return nil
+	case fields[0] == "cgp" && len(fields) == 2 && c.group != nil:
+		var resp []string
+		for name := range c.group {
+			if c.changed[name] {
+				resp = append(resp, value(name))
+				delete(c.changed, name)
+			}
+		}
+
+		return resp
+	case fields[0] == "cgd" && len(fields) == 2:
+		c.group = nil
+		return nil
+	default:
+		return []string{"bad_command"}
+	}
+}
@@ -1,19 +1,27 @@
 package device
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
-	"github.com/byuoitav/connpool"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// SetControlRequest is the body of a request to set a control.
+type SetControlRequest struct {
+	// Value is a number, string or boolean
+	Value interface{} `json:"value"`
+
+	// Ramp is how many seconds the core takes to move the control to Value
+	Ramp float64 `json:"ramp"`
+}
+
 func (dm *DeviceManager) HandlerGetGeneric(ctx *gin.Context) {
 	addr := ctx.Param("address")
 	name := ctx.Param("name")
@@ -27,151 +35,252 @@ func (dm *DeviceManager) HandlerGetGeneric(ctx *gin.Context) {
 	val, err := dsp.Control(c, name)
 	if err != nil {
 		dm.Log.Error("unable to get control", zap.Error(err))
-		ctx.String(http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 
 	dm.Log.Debug("Got control", zap.String("address", addr), zap.String("value", fmt.Sprintf("%+v", val)))
-	ctx.JSON(http.StatusOK, map[string]float64{
-		name: val,
+
+	// ?full=true returns the string and position of the control along with its value
+	if full, _ := strconv.ParseBool(ctx.Query("full")); full {
+		ctx.JSON(http.StatusOK, val)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		name: val.value(),
 	})
 }
 
 func (dm *DeviceManager) HandlerSetGeneric(ctx *gin.Context) {
-	addr := ctx.Param("address")
-	name := ctx.Param("name")
-	val, err := strconv.ParseFloat(ctx.Param("value"), 64)
-	if err != nil {
-		ctx.String(http.StatusBadRequest, err.Error())
+	val := parseValue(ctx.Param("value"))
+
+	var ramp time.Duration
+	if r := ctx.Query("ramp"); r != "" {
+		var err error
+		ramp, err = time.ParseDuration(r)
+		switch {
+		case err != nil:
+			writeError(ctx, fmt.Errorf("%w: could not parse ramp: %w", ErrInvalidParams, err))
+			return
+		case ramp < 0:
+			writeError(ctx, fmt.Errorf("%w: ramp must not be negative", ErrInvalidParams))
+			return
+		}
+	}
+
+	dm.setGeneric(ctx, val, ramp)
+}
+
+func (dm *DeviceManager) HandlerSetGenericJSON(ctx *gin.Context) {
+	var req SetControlRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	if req.Ramp < 0 {
+		writeError(ctx, fmt.Errorf("%w: ramp must not be negative", ErrInvalidParams))
 		return
 	}
 
+	dm.setGeneric(ctx, req.Value, time.Duration(req.Ramp*float64(time.Second)))
+}
+
+func (dm *DeviceManager) setGeneric(ctx *gin.Context, val interface{}, ramp time.Duration) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
 	dsp := dm.CreateDSP(addr)
 
-	dm.Log.Debug("setting control value", zap.String("address", addr), zap.String("name", name), zap.Float64("value", val))
+	dm.Log.Debug("setting control value", zap.String("address", addr), zap.String("name", name), zap.Any("value", val), zap.Duration("ramp", ramp))
 
 	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	err = dsp.SetControl(c, name, val)
+	res, err := dsp.RampControl(c, name, val, ramp)
 	if err != nil {
 		dm.Log.Error("unable to set control", zap.Error(err))
-		ctx.String(http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 
 	dm.Log.Debug("Set control", zap.String("address", addr))
-	ctx.JSON(http.StatusOK, map[string]float64{
+
+	// ?full=true returns the state the core sent back instead of the value that was set
+	if full, _ := strconv.ParseBool(ctx.Query("full")); full {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]interface{}{
 		name: val,
 	})
 }
 
-func (d *DSP) SetControl(ctx context.Context, name string, value float64) error {
+// _urlNumber matches numbers written the way JSON writes them, so that values like "007" or "0x10" stay strings.
+var _urlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// parseValue turns a value from a url into a number if it is a finite number, or a boolean if it is exactly true or false.
+// Everything else is left as a string; the JSON body can be used to send a value with an exact type.
+func parseValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if _urlNumber.MatchString(s) {
+		// numbers too large for a float64 are left as strings
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+
+	return s
+}
+
+// SetControl sets a named control to value, which must be a number, string or boolean.
+func (d *DSP) SetControl(ctx context.Context, name string, value interface{}) (QSCGetStatusResult, error) {
+	return d.RampControl(ctx, name, value, 0)
+}
+
+// RampControl moves a control to value over the ramp time. The ramp happens on the core,
+// so this returns as soon as the ramp has started. A control that is set without a ramp is updated
+// in the change group right away, but a ramping one is left for the change group to follow as it moves,
+// since the value that was sent is only where the ramp ends.
+func (d *DSP) RampControl(ctx context.Context, name string, value interface{}, ramp time.Duration) (QSCGetStatusResult, error) {
+	wait, err := d.startRampControl(ctx, name, value, ramp)
+	if err != nil {
+		return QSCGetStatusResult{}, err
+	}
+
+	return wait(ctx)
+}
+
+// startRampControl sends the Control.Set for RampControl, and returns a function that waits for its result.
+func (d *DSP) startRampControl(ctx context.Context, name string, value interface{}, ramp time.Duration) (func(context.Context) (QSCGetStatusResult, error), error) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
 	req := d.GetGenericSetStatusRequest(ctx)
 	req.Params.Name = name
 	req.Params.Value = value
+	req.Params.Ramp = ramp.Seconds()
+
+	d.log.Info("Setting control", zap.String("name", name), zap.Any("value", value), zap.Duration("ramp", ramp))
 
-	toSend, err := json.Marshal(req)
+	p, err := d.client.Start(ctx, req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	toSend = append(toSend, 0x00)
 
-	var resp []byte
-	err = d.pool.Do(ctx, func(conn connpool.Conn) error {
-		d.log.Info("Setting control", zap.String("name", name), zap.Float64("value", value))
-
-		deadline, ok := ctx.Deadline()
-		if !ok {
-			deadline = time.Now().Add(3 * time.Second)
+	return func(ctx context.Context) (QSCGetStatusResult, error) {
+		resp, err := p.Wait(ctx)
+		if err != nil {
+			return QSCGetStatusResult{}, err
 		}
 
-		conn.SetWriteDeadline(deadline)
-
-		n, err := conn.Write(toSend)
-		switch {
-		case err != nil:
-			return fmt.Errorf("unable to write command to set volume: %v", err)
-		case n != len(toSend):
-			return fmt.Errorf("unable to write command to set volume: wrote %v/%v bytes", n, len(toSend))
+		qscResp := QSCSetStatusResponse{}
+		if err := json.Unmarshal(resp, &qscResp); err != nil {
+			return QSCGetStatusResult{}, fmt.Errorf("unable to parse response: %w", err)
 		}
 
-		resp, err = conn.ReadUntil('\x00', deadline)
-		if err != nil {
-			return fmt.Errorf("unable to read response: %w", err)
+		if qscResp.Result.Name != name {
+			return QSCGetStatusResult{}, fmt.Errorf("response name (%s) does not match the name sent (%s)", qscResp.Result.Name, name)
 		}
 
-		d.log.Debug("Got response", zap.ByteString("response", resp))
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	resp = bytes.Trim(resp, "\x00")
+		if ramp <= 0 {
+			d.changes.set("", name, value)
+		}
 
-	qscResp := QSCSetStatusResponse{}
-	if err := json.Unmarshal(resp, &qscResp); err != nil {
-		return fmt.Errorf("unable to parse response: %w", err)
-	}
+		return qscResp.Result, nil
+	}, nil
+}
 
-	if qscResp.Result.Name != name {
-		return fmt.Errorf("response name (%s) does not match the name sent (%s)", qscResp.Result.Name, name)
+// Control gets the current value, string, and position of a named control.
+func (d *DSP) Control(ctx context.Context, name string) (QSCGetStatusResult, error) {
+	results, err := d.Controls(ctx, name)
+	if err != nil {
+		return QSCGetStatusResult{}, err
 	}
 
-	return nil
+	return results[0], nil
 }
 
-func (d *DSP) Control(ctx context.Context, name string) (float64, error) {
+// Controls gets the current value, string, and position of named controls with a single Control.Get.
+// Results are in the same order as names.
+func (d *DSP) Controls(ctx context.Context, names ...string) ([]QSCGetStatusResult, error) {
 	req := d.GetGenericGetStatusRequest(ctx)
-	req.Params = append(req.Params, name)
+	req.Params = append(req.Params, names...)
+
+	d.log.Info("Getting controls", zap.Strings("names", names))
 
-	toSend, err := json.Marshal(req)
+	resp, err := d.client.Do(ctx, req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	toSend = append(toSend, 0x00)
 
-	var resp []byte
-	err = d.pool.Do(ctx, func(conn connpool.Conn) error {
-		d.log.Info("Getting control", zap.String("name", name))
+	qscResp := QSCGetStatusResponse{}
+	if err := json.Unmarshal(resp, &qscResp); err != nil {
+		return nil, fmt.Errorf("unable to parse response: %w", err)
+	}
 
-		deadline, ok := ctx.Deadline()
+	byName := make(map[string]QSCGetStatusResult, len(qscResp.Result))
+	for _, res := range qscResp.Result {
+		byName[res.Name] = res
+	}
+
+	results := make([]QSCGetStatusResult, 0, len(names))
+	for _, name := range names {
+		res, ok := byName[name]
 		if !ok {
-			deadline = time.Now().Add(3 * time.Second)
+			return nil, fmt.Errorf("%w: no value returned for %s: '%s'", ErrInvalidControl, name, resp)
 		}
 
-		conn.SetWriteDeadline(deadline)
+		results = append(results, res)
+	}
 
-		n, err := conn.Write(toSend)
-		switch {
-		case err != nil:
-			return fmt.Errorf("unable to write command: %w", err)
-		case n != len(toSend):
-			return fmt.Errorf("unable to write command: wrote %v/%v bytes", n, len(toSend))
+	return results, nil
+}
+
+// readControls gets the state of named controls, from the change group if they are in it.
+// The rest are read with a single Control.Get. Every control is watched, so later reads come from memory.
+func (d *DSP) readControls(ctx context.Context, names []string) (map[string]QSCGetStatusResult, error) {
+	vals := make(map[string]QSCGetStatusResult, len(names))
+
+	var missing []string
+	for _, name := range names {
+		if _, ok := vals[name]; ok {
+			continue
 		}
 
-		resp, err = conn.ReadUntil('\x00', deadline)
-		if err != nil {
-			return fmt.Errorf("unable to read response: %w", err)
+		if val, ok := d.changes.Value("", name); ok {
+			vals[name] = QSCGetStatusResult{Name: name, Value: val.Value, String: val.String, Position: val.Position}
+			continue
 		}
 
-		d.log.Debug("Got response", zap.ByteString("response", resp))
-		return nil
-	})
-	if err != nil {
-		return 0, err
+		missing = append(missing, name)
+		vals[name] = QSCGetStatusResult{}
 	}
 
-	resp = bytes.Trim(resp, "\x00")
-	qscResp := QSCGetStatusResponse{}
-	if err := json.Unmarshal(resp, &qscResp); err != nil {
-		return 0, fmt.Errorf("unable to parse response: %w", err)
+	if len(missing) == 0 {
+		d.watch(names...)
+		return vals, nil
+	}
+
+	results, err := d.Controls(ctx, missing...)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(qscResp.Result) == 0 {
-		return 0, fmt.Errorf("no results in response: '%s'", resp)
+	for _, res := range results {
+		vals[res.Name] = res
 	}
 
-	return qscResp.Result[0].Value, nil
+	d.watch(names...)
+	return vals, nil
 }
@@ -1,7 +1,6 @@
 package device
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/byuoitav/connpool"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -26,7 +24,7 @@ func (dm *DeviceManager) HandlerGetInfo(ctx *gin.Context) {
 	info, err := dsp.Info(c)
 	if err != nil {
 		dm.Log.Error("unable to get hardware info", zap.String("address", addr), zap.Error(err))
-		ctx.String(http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 
@@ -44,6 +42,12 @@ type Info struct {
 	State      string
 	StatusCode string
 	RawState   string
+
+	// ActiveCore is the address of the core commands are sent to, for redundant pairs
+	ActiveCore string `json:",omitempty"`
+
+	// Cores is the last known state of each core, for redundant pairs
+	Cores []CoreState `json:",omitempty"`
 }
 
 // Info is all the juicy details about the QSC that everyone is DYING to know about
@@ -53,10 +57,9 @@ func (d *DSP) Info(ctx context.Context) (interface{}, error) {
 	var details Info
 
 	var addr string
-	d.pool.Do(ctx, func(conn connpool.Conn) error {
-		addr = strings.Split(conn.RemoteAddr().String(), ":")[0]
-		return fmt.Errorf("provided address is in an invalid format")
-	})
+	if remote, err := d.client.RemoteAddr(ctx); err == nil {
+		addr = strings.Split(remote.String(), ":")[0]
+	}
 
 	// get the hostname
 	hostname, e := net.LookupAddr(addr)
@@ -68,7 +71,7 @@ func (d *DSP) Info(ctx context.Context) (interface{}, error) {
 
 	resp, err := d.GetStatus(ctx)
 	if err != nil {
-		return details, fmt.Errorf("there was an error getting the status: %v", err)
+		return details, fmt.Errorf("there was an error getting the status: %w", err)
 	}
 
 	d.log.Info("response", zap.Any("response", resp))
@@ -78,6 +81,11 @@ func (d *DSP) Info(ctx context.Context) (interface{}, error) {
 
 	details.IPAddress = addr
 
+	if d.pair != nil {
+		details.ActiveCore = d.pair.Active()
+		details.Cores = d.pair.States()
+	}
+
 	rawString, _ := json.Marshal(resp)
 	details.RawState = string(rawString)
 
@@ -88,7 +96,7 @@ func (d *DSP) Info(ctx context.Context) (interface{}, error) {
 func (d *DSP) Healthy(ctx context.Context) error {
 	_, err := d.GetStatus(ctx)
 	if err != nil {
-		return fmt.Errorf("failed health check: %s", err)
+		return fmt.Errorf("failed health check: %w", err)
 	}
 
 	return nil
@@ -101,47 +109,13 @@ func (d *DSP) GetStatus(ctx context.Context) (QSCStatusGetResponse, error) {
 	d.log.Info("In GetStatus...")
 	toReturn := QSCStatusGetResponse{}
 
-	toSend, err := json.Marshal(req)
-	if err != nil {
-		return toReturn, err
-	}
-
-	toSend = append(toSend, 0x00)
-
-	var resp []byte
-	err = d.pool.Do(ctx, func(conn connpool.Conn) error {
-		d.log.Info("getting status")
-
-		conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
-
-		n, err := conn.Write(toSend)
-		switch {
-		case err != nil:
-			return fmt.Errorf("unable to write command to get status: %v", err)
-		case n != len(toSend):
-			return fmt.Errorf("unable to write command to get status: wrote %v/%v bytes", n, len(toSend))
-		}
-
-		deadline, ok := ctx.Deadline()
-		if !ok {
-			deadline = time.Now().Add(3 * time.Second)
-		}
+	d.log.Info("getting status")
 
-		resp, err = conn.ReadUntil('\x00', deadline)
-		if err != nil {
-			return fmt.Errorf("unable to read response: %w", err)
-		}
-
-		d.log.Debug("Got response: %v", zap.Any("response", resp))
-
-		return nil
-	})
+	resp, err := d.client.Do(ctx, req)
 	if err != nil {
 		return toReturn, err
 	}
 
-	resp = bytes.Trim(resp, "\x00")
-
 	err = json.Unmarshal(resp, &toReturn)
 	if err != nil {
 		d.log.Info(err.Error())
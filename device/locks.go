@@ -0,0 +1,47 @@
+package device
+
+import "sync"
+
+// controlLocks serializes read-modify-write operations on a single control,
+// so that two requests changing the same control at once don't both start from the same state.
+type controlLocks struct {
+	mu    sync.Mutex
+	locks map[string]*controlLock
+}
+
+type controlLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newControlLocks() *controlLocks {
+	return &controlLocks{
+		locks: make(map[string]*controlLock),
+	}
+}
+
+// lock locks the named control, and returns the function that unlocks it.
+func (l *controlLocks) lock(name string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &controlLock{}
+		l.locks[name] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QSCMixerRequest is for the Mixer.* methods
+type QSCMixerRequest struct {
+	BaseRequest
+	Params QSCMixerParams `json:"params"`
+}
+
+// QSCMixerParams is the parameters for the Mixer.* methods.
+// Inputs, Outputs and Cues are channel strings, like "1-4 6"; only the ones a method uses are sent.
+type QSCMixerParams struct {
+	Name    string
+	Inputs  string `json:",omitempty"`
+	Outputs string `json:",omitempty"`
+	Cues    string `json:",omitempty"`
+
+	// Value is a float64 for gains and delays, and a bool for mutes, solos, and cue settings
+	Value interface{}
+
+	// Ramp is how many seconds the core takes to move gains and delays to Value
+	Ramp float64 `json:",omitempty"`
+}
+
+// MixerRequest is the body of a request to change a mixer.
+// Inputs, Outputs and Cues are channel strings, like "1-4 6", "*" or "* !3".
+type MixerRequest struct {
+	Inputs  string `json:"inputs,omitempty"`
+	Outputs string `json:"outputs,omitempty"`
+	Cues    string `json:"cues,omitempty"`
+
+	// Value is a number for gains (in dB) and delays (in seconds), and a boolean for everything else
+	Value interface{} `json:"value"`
+
+	// Ramp is how many seconds the core takes to move gains and delays to Value
+	Ramp float64 `json:"ramp,omitempty"`
+}
+
+func (r MixerRequest) number() (float64, error) {
+	v, ok := r.Value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%w: value must be a number", ErrInvalidParams)
+	}
+
+	return v, nil
+}
+
+func (r MixerRequest) bool() (bool, error) {
+	v, ok := r.Value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: value must be a boolean", ErrInvalidParams)
+	}
+
+	return v, nil
+}
+
+func (r MixerRequest) ramp() time.Duration {
+	return time.Duration(r.Ramp * float64(time.Second))
+}
+
+// MixerMatrix is the state of every input, output and crosspoint of a mixer.
+// Controls that the mixer doesn't have are left out.
+type MixerMatrix struct {
+	Name    string       `json:"name"`
+	Inputs  []MixerStrip `json:"inputs"`
+	Outputs []MixerStrip `json:"outputs"`
+
+	// CrossPoints is indexed by input, then output, in the same order as Inputs and Outputs
+	CrossPoints [][]MixerCrossPoint `json:"crosspoints"`
+}
+
+// MixerStrip is the state of a single input or output of a mixer.
+type MixerStrip struct {
+	Channel int      `json:"channel"`
+	Label   string   `json:"label,omitempty"`
+	Gain    *float64 `json:"gain,omitempty"`
+	Mute    *bool    `json:"mute,omitempty"`
+	Solo    *bool    `json:"solo,omitempty"`
+}
+
+// MixerCrossPoint is the state of the crosspoint between an input and an output of a mixer.
+type MixerCrossPoint struct {
+	Input  int      `json:"input"`
+	Output int      `json:"output"`
+	Gain   *float64 `json:"gain,omitempty"`
+	Mute   *bool    `json:"mute,omitempty"`
+	Delay  *float64 `json:"delay,omitempty"`
+	Solo   *bool    `json:"solo,omitempty"`
+}
+
+var (
+	_mixerInput      = regexp.MustCompile(`^input\.(\d+)\.(gain|mute|solo|label)$`)
+	_mixerOutput     = regexp.MustCompile(`^output\.(\d+)\.(gain|mute|solo|label)$`)
+	_mixerCrossPoint = regexp.MustCompile(`^input\.(\d+)\.output\.(\d+)\.(gain|mute|delay|solo)$`)
+)
+
+// validateChannels checks the syntax of a channel string without knowing how many channels there are.
+func validateChannels(channels string) error {
+	tokens := strings.Fields(channels)
+	if len(tokens) == 0 {
+		return fmt.Errorf("%w: no channels given", ErrInvalidParams)
+	}
+
+	for _, token := range tokens {
+		token = strings.TrimPrefix(token, "!")
+		if token == "*" {
+			continue
+		}
+
+		if _, _, err := parseChannelRange(token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseChannelRange parses "6" or "1-4".
+func parseChannelRange(token string) (int, int, error) {
+	firstStr, lastStr, isRange := strings.Cut(token, "-")
+
+	first, err := strconv.Atoi(firstStr)
+	if err != nil || first < 1 {
+		return 0, 0, fmt.Errorf("%w: invalid channel %q", ErrInvalidParams, token)
+	}
+
+	if !isRange {
+		return first, first, nil
+	}
+
+	last, err := strconv.Atoi(lastStr)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("%w: invalid channel range %q", ErrInvalidParams, token)
+	}
+
+	return first, last, nil
+}
+
+func (d *DSP) GetGenericMixerRequest(ctx context.Context, method string) QSCMixerRequest {
+	return QSCMixerRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: method}, Params: QSCMixerParams{}}
+}
+
+// handleMixer parses a MixerRequest and runs set with it.
+func (dm *DeviceManager) handleMixer(ctx *gin.Context, action string, set func(context.Context, *DSP, string, MixerRequest) error) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	var req MixerRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	if req.Ramp < 0 {
+		writeError(ctx, fmt.Errorf("%w: ramp must not be negative", ErrInvalidParams))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug(action, zap.String("address", addr), zap.String("mixer", name), zap.Any("request", req))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := set(c, dsp, name, req); err != nil {
+		dm.Log.Error("unable to set mixer", zap.String("address", addr), zap.String("mixer", name), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Set mixer", zap.String("address", addr), zap.String("mixer", name))
+	ctx.JSON(http.StatusOK, req)
+}
+
+func (dm *DeviceManager) HandlerGetMixerMatrix(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("getting mixer matrix", zap.String("address", addr), zap.String("mixer", name))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	matrix, err := dsp.MixerMatrix(c, name)
+	if err != nil {
+		dm.Log.Error("unable to get mixer matrix", zap.String("address", addr), zap.String("mixer", name), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got mixer matrix", zap.String("address", addr), zap.String("mixer", name), zap.Int("inputs", len(matrix.Inputs)), zap.Int("outputs", len(matrix.Outputs)))
+	ctx.JSON(http.StatusOK, matrix)
+}
+
+func (dm *DeviceManager) HandlerSetCrossPointGain(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting crosspoint gain", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		gain, err := req.number()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCrossPointGain(c, mixer, req.Inputs, req.Outputs, gain, req.ramp())
+	})
+}
+
+func (dm *DeviceManager) HandlerSetCrossPointMute(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting crosspoint mute", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		mute, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCrossPointMute(c, mixer, req.Inputs, req.Outputs, mute)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetCrossPointDelay(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting crosspoint delay", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		delay, err := req.number()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCrossPointDelay(c, mixer, req.Inputs, req.Outputs, time.Duration(delay*float64(time.Second)), req.ramp())
+	})
+}
+
+func (dm *DeviceManager) HandlerSetCrossPointSolo(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting crosspoint solo", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		solo, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCrossPointSolo(c, mixer, req.Inputs, req.Outputs, solo)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetInputGain(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting input gain", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		gain, err := req.number()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetInputGain(c, mixer, req.Inputs, gain, req.ramp())
+	})
+}
+
+func (dm *DeviceManager) HandlerSetInputMute(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting input mute", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		mute, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetInputMute(c, mixer, req.Inputs, mute)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetInputSolo(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting input solo", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		solo, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetInputSolo(c, mixer, req.Inputs, solo)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetOutputGain(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting output gain", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		gain, err := req.number()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetOutputGain(c, mixer, req.Outputs, gain, req.ramp())
+	})
+}
+
+func (dm *DeviceManager) HandlerSetOutputMute(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting output mute", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		mute, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetOutputMute(c, mixer, req.Outputs, mute)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetCueGain(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting cue gain", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		gain, err := req.number()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCueGain(c, mixer, req.Cues, gain, req.ramp())
+	})
+}
+
+func (dm *DeviceManager) HandlerSetCueMute(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting cue mute", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		mute, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetCueMute(c, mixer, req.Cues, mute)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetInputCueEnable(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting input cue enable", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		enable, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetInputCueEnable(c, mixer, req.Cues, req.Inputs, enable)
+	})
+}
+
+func (dm *DeviceManager) HandlerSetInputCueAfl(ctx *gin.Context) {
+	dm.handleMixer(ctx, "setting input cue afl", func(c context.Context, dsp *DSP, mixer string, req MixerRequest) error {
+		afl, err := req.bool()
+		if err != nil {
+			return err
+		}
+
+		return dsp.SetInputCueAfl(c, mixer, req.Cues, req.Inputs, afl)
+	})
+}
+
+// SetCrossPointGain sets the gain, in dB, of the crosspoints between inputs and outputs over the ramp time.
+func (d *DSP) SetCrossPointGain(ctx context.Context, mixer, inputs, outputs string, gain float64, ramp time.Duration) error {
+	return d.setMixer(ctx, "Mixer.SetCrossPointGain", QSCMixerParams{Name: mixer, Inputs: inputs, Outputs: outputs, Value: gain, Ramp: ramp.Seconds()}, mixerChannels{"inputs", inputs}, mixerChannels{"outputs", outputs})
+}
+
+// SetCrossPointMute mutes or unmutes the crosspoints between inputs and outputs.
+func (d *DSP) SetCrossPointMute(ctx context.Context, mixer, inputs, outputs string, mute bool) error {
+	return d.setMixer(ctx, "Mixer.SetCrossPointMute", QSCMixerParams{Name: mixer, Inputs: inputs, Outputs: outputs, Value: mute}, mixerChannels{"inputs", inputs}, mixerChannels{"outputs", outputs})
+}
+
+// SetCrossPointDelay sets the delay of the crosspoints between inputs and outputs over the ramp time.
+// The mixer must have crosspoint delay enabled.
+func (d *DSP) SetCrossPointDelay(ctx context.Context, mixer, inputs, outputs string, delay, ramp time.Duration) error {
+	return d.setMixer(ctx, "Mixer.SetCrossPointDelay", QSCMixerParams{Name: mixer, Inputs: inputs, Outputs: outputs, Value: delay.Seconds(), Ramp: ramp.Seconds()}, mixerChannels{"inputs", inputs}, mixerChannels{"outputs", outputs})
+}
+
+// SetCrossPointSolo solos or unsolos the crosspoints between inputs and outputs.
+func (d *DSP) SetCrossPointSolo(ctx context.Context, mixer, inputs, outputs string, solo bool) error {
+	return d.setMixer(ctx, "Mixer.SetCrossPointSolo", QSCMixerParams{Name: mixer, Inputs: inputs, Outputs: outputs, Value: solo}, mixerChannels{"inputs", inputs}, mixerChannels{"outputs", outputs})
+}
+
+// SetInputGain sets the gain, in dB, of inputs over the ramp time.
+func (d *DSP) SetInputGain(ctx context.Context, mixer, inputs string, gain float64, ramp time.Duration) error {
+	return d.setMixer(ctx, "Mixer.SetInputGain", QSCMixerParams{Name: mixer, Inputs: inputs, Value: gain, Ramp: ramp.Seconds()}, mixerChannels{"inputs", inputs})
+}
+
+// SetInputMute mutes or unmutes inputs.
+func (d *DSP) SetInputMute(ctx context.Context, mixer, inputs string, mute bool) error {
+	return d.setMixer(ctx, "Mixer.SetInputMute", QSCMixerParams{Name: mixer, Inputs: inputs, Value: mute}, mixerChannels{"inputs", inputs})
+}
+
+// SetInputSolo solos or unsolos inputs.
+func (d *DSP) SetInputSolo(ctx context.Context, mixer, inputs string, solo bool) error {
+	return d.setMixer(ctx, "Mixer.SetInputSolo", QSCMixerParams{Name: mixer, Inputs: inputs, Value: solo}, mixerChannels{"inputs", inputs})
+}
+
+// SetOutputGain sets the gain, in dB, of outputs over the ramp time.
+func (d *DSP) SetOutputGain(ctx context.Context, mixer, outputs string, gain float64, ramp time.Duration) error {
+	return d.setMixer(ctx, "Mixer.SetOutputGain", QSCMixerParams{Name: mixer, Outputs: outputs, Value: gain, Ramp: ramp.Seconds()}, mixerChannels{"outputs", outputs})
+}
+
+// SetOutputMute mutes or unmutes outputs.
+func (d *DSP) SetOutputMute(ctx context.Context, mixer, outputs string, mute bool) error {
+	return d.setMixer(ctx, "Mixer.SetOutputMute", QSCMixerParams{Name: mixer, Outputs: outputs, Value: mute}, mixerChannels{"outputs", outputs})
+}
+
+// SetCueGain sets the gain, in dB, of cues over the ramp time.
+func (d *DSP) SetCueGain(ctx context.Context, mixer, cues string, gain float64, ramp time.Duration) error {
+	return d.setMixer(ctx, "Mixer.SetCueGain", QSCMixerParams{Name: mixer, Cues: cues, Value: gain, Ramp: ramp.Seconds()}, mixerChannels{"cues", cues})
+}
+
+// SetCueMute mutes or unmutes cues.
+func (d *DSP) SetCueMute(ctx context.Context, mixer, cues string, mute bool) error {
+	return d.setMixer(ctx, "Mixer.SetCueMute", QSCMixerParams{Name: mixer, Cues: cues, Value: mute}, mixerChannels{"cues", cues})
+}
+
+// SetInputCueEnable enables or disables inputs on cues.
+func (d *DSP) SetInputCueEnable(ctx context.Context, mixer, cues, inputs string, enable bool) error {
+	return d.setMixer(ctx, "Mixer.SetInputCueEnable", QSCMixerParams{Name: mixer, Cues: cues, Inputs: inputs, Value: enable}, mixerChannels{"inputs", inputs}, mixerChannels{"cues", cues})
+}
+
+// SetInputCueAfl turns after-fader listen of inputs on cues on or off.
+func (d *DSP) SetInputCueAfl(ctx context.Context, mixer, cues, inputs string, afl bool) error {
+	return d.setMixer(ctx, "Mixer.SetInputCueAfl", QSCMixerParams{Name: mixer, Cues: cues, Inputs: inputs, Value: afl}, mixerChannels{"inputs", inputs}, mixerChannels{"cues", cues})
+}
+
+// mixerLayout is the names of the matrix controls of a mixer in a design.
+type mixerLayout struct {
+	design string
+	names  []string
+}
+
+// MixerMatrix reads every input, output and crosspoint of a mixer.
+// The first read lists the mixer's controls with Component.GetControls to learn its size, which also returns their values.
+// Later reads only ask for the matrix controls with Component.Get, until the design on the core changes.
+func (d *DSP) MixerMatrix(ctx context.Context, mixer string) (MixerMatrix, error) {
+	design := d.designCode()
+
+	d.mixersMu.Lock()
+	layout, ok := d.mixers[mixer]
+	d.mixersMu.Unlock()
+
+	if ok && layout.design == design {
+		values, err := d.Component(ctx, mixer, layout.names...)
+		switch {
+		case err == nil:
+			return mixerMatrix(mixer, values)
+		case !errors.Is(err, ErrInvalidControl):
+			return MixerMatrix{}, err
+		}
+
+		// the mixer changed without the design code changing, so its controls are listed again
+		d.log.Info("Mixer controls changed, listing them again", zap.String("mixer", mixer), zap.Error(err))
+	}
+
+	controls, err := d.ComponentControls(ctx, mixer)
+	if err != nil {
+		return MixerMatrix{}, err
+	}
+
+	var values []QSCGetStatusResult
+	for _, control := range controls {
+		if isMixerControl(control.Name) {
+			values = append(values, QSCGetStatusResult{Name: control.Name, Value: control.Value, String: control.String, Position: control.Position})
+		}
+	}
+
+	matrix, err := mixerMatrix(mixer, values)
+	if err != nil {
+		return MixerMatrix{}, err
+	}
+
+	layout = mixerLayout{design: design, names: make([]string, len(values))}
+	for i, value := range values {
+		layout.names[i] = value.Name
+	}
+
+	d.mixersMu.Lock()
+	d.mixers[mixer] = layout
+	d.mixersMu.Unlock()
+
+	return matrix, nil
+}
+
+// designCode returns the code of the design running on the core, or "" if the core hasn't sent it.
+func (d *DSP) designCode() string {
+	d.engineMu.RLock()
+	defer d.engineMu.RUnlock()
+
+	if d.engine == nil {
+		return ""
+	}
+
+	return d.engine.DesignCode
+}
+
+func isMixerControl(name string) bool {
+	return _mixerCrossPoint.MatchString(name) || _mixerInput.MatchString(name) || _mixerOutput.MatchString(name)
+}
+
+// mixerMatrix builds the matrix of a mixer from the values of its matrix controls, sizing it from their names.
+func mixerMatrix(mixer string, values []QSCGetStatusResult) (MixerMatrix, error) {
+	inputs := make(map[int]bool)
+	outputs := make(map[int]bool)
+	for _, value := range values {
+		if m := _mixerCrossPoint.FindStringSubmatch(value.Name); m != nil {
+			in, _ := strconv.Atoi(m[1])
+			out, _ := strconv.Atoi(m[2])
+			inputs[in] = true
+			outputs[out] = true
+		} else if m := _mixerInput.FindStringSubmatch(value.Name); m != nil {
+			in, _ := strconv.Atoi(m[1])
+			inputs[in] = true
+		} else if m := _mixerOutput.FindStringSubmatch(value.Name); m != nil {
+			out, _ := strconv.Atoi(m[1])
+			outputs[out] = true
+		}
+	}
+
+	if len(inputs) == 0 && len(outputs) == 0 {
+		return MixerMatrix{}, fmt.Errorf("%w: %s is not a mixer", ErrInvalidParams, mixer)
+	}
+
+	matrix := MixerMatrix{Name: mixer}
+
+	inputIndex := make(map[int]int)
+	for _, ch := range sortedChannels(inputs) {
+		inputIndex[ch] = len(matrix.Inputs)
+		matrix.Inputs = append(matrix.Inputs, MixerStrip{Channel: ch})
+	}
+
+	outputIndex := make(map[int]int)
+	for _, ch := range sortedChannels(outputs) {
+		outputIndex[ch] = len(matrix.Outputs)
+		matrix.Outputs = append(matrix.Outputs, MixerStrip{Channel: ch})
+	}
+
+	matrix.CrossPoints = make([][]MixerCrossPoint, len(matrix.Inputs))
+	for i, input := range matrix.Inputs {
+		matrix.CrossPoints[i] = make([]MixerCrossPoint, len(matrix.Outputs))
+		for o, output := range matrix.Outputs {
+			matrix.CrossPoints[i][o] = MixerCrossPoint{Input: input.Channel, Output: output.Channel}
+		}
+	}
+
+	for _, value := range values {
+		value := value
+		mute := value.Value != 0
+
+		if m := _mixerCrossPoint.FindStringSubmatch(value.Name); m != nil {
+			in, _ := strconv.Atoi(m[1])
+			out, _ := strconv.Atoi(m[2])
+			cp := &matrix.CrossPoints[inputIndex[in]][outputIndex[out]]
+
+			switch m[3] {
+			case "gain":
+				cp.Gain = &value.Value
+			case "mute":
+				cp.Mute = &mute
+			case "delay":
+				cp.Delay = &value.Value
+			case "solo":
+				cp.Solo = &mute
+			}
+
+			continue
+		}
+
+		var strip *MixerStrip
+		var property string
+		if m := _mixerInput.FindStringSubmatch(value.Name); m != nil {
+			in, _ := strconv.Atoi(m[1])
+			strip, property = &matrix.Inputs[inputIndex[in]], m[2]
+		} else if m := _mixerOutput.FindStringSubmatch(value.Name); m != nil {
+			out, _ := strconv.Atoi(m[1])
+			strip, property = &matrix.Outputs[outputIndex[out]], m[2]
+		} else {
+			continue
+		}
+
+		switch property {
+		case "gain":
+			strip.Gain = &value.Value
+		case "mute":
+			strip.Mute = &mute
+		case "solo":
+			strip.Solo = &mute
+		case "label":
+			strip.Label = value.String
+		}
+	}
+
+	return matrix, nil
+}
+
+func sortedChannels(channels map[int]bool) []int {
+	sorted := make([]int, 0, len(channels))
+	for ch := range channels {
+		sorted = append(sorted, ch)
+	}
+
+	sort.Ints(sorted)
+	return sorted
+}
+
+// mixerChannels is a channel string that a mixer method uses, and the name of its parameter.
+type mixerChannels struct {
+	name  string
+	value string
+}
+
+// setMixer checks the channel strings a method uses, and sends params to the core with method.
+func (d *DSP) setMixer(ctx context.Context, method string, params QSCMixerParams, channels ...mixerChannels) error {
+	for _, ch := range channels {
+		if err := validateChannels(ch.value); err != nil {
+			return fmt.Errorf("%s: %w", ch.name, err)
+		}
+	}
+
+	req := d.GetGenericMixerRequest(ctx, method)
+	req.Params = params
+
+	d.log.Info("Setting mixer", zap.String("method", method), zap.String("mixer", params.Name), zap.String("inputs", params.Inputs), zap.String("outputs", params.Outputs), zap.String("cues", params.Cues), zap.Any("value", params.Value))
+
+	_, err := d.client.Do(ctx, req)
+	return err
+}
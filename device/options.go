@@ -1,15 +1,45 @@
 package device
 
 import (
+	"crypto/tls"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 type options struct {
-	ttl    time.Duration
-	delay  time.Duration
-	logger *zap.Logger
+	ttl         time.Duration
+	delay       time.Duration
+	keepAlive   time.Duration
+	logger      *zap.Logger
+	credentials CredentialsFunc
+	pollRate    time.Duration
+	volumeModes map[string]VolumeMode
+	curves      map[string]VolumeCurve
+	protocol    Protocol
+	tlsConfig   *tls.Config
+	backup      string
+
+	// dial overrides the transport for the protocol
+	dial DialFunc
+}
+
+// dialer returns how connections to the core are opened for the chosen protocol.
+func (o options) dialer() DialFunc {
+	if o.dial != nil {
+		return o.dial
+	}
+
+	switch o.protocol {
+	case ProtocolECP:
+		return dialECP
+	case ProtocolWebSocket:
+		return dialWebSocket(false, nil)
+	case ProtocolSecureWebSocket:
+		return dialWebSocket(true, o.tlsConfig)
+	default:
+		return dialTCP
+	}
 }
 
 // Option configures how we create the DSP.
@@ -24,8 +54,9 @@ func (f optionFunc) apply(o *options) {
 }
 
 // WithTTL changes the TTL for the underlying TCP connection to the DSP.
+// The connection is closed once no command has been sent on it for this long, even while keepalives are on,
+// and it is only reopened after it fails if a command was sent within the TTL.
 // The default value is 30 seconds.
-// See more details about TTL in https://github.com/byuoitav/connpool.
 func WithTTL(t time.Duration) Option {
 	return optionFunc(func(o *options) {
 		o.ttl = t
@@ -33,14 +64,61 @@ func WithTTL(t time.Duration) Option {
 }
 
 // WithDelay changes the delay between sending commands to the DSP.
-// The default value is 250 milliseconds.
-// See more details about delay in https://github.com/byuoitav/connpool.
+// The default value is 0, since responses are matched to requests by their id.
 func WithDelay(t time.Duration) Option {
 	return optionFunc(func(o *options) {
 		o.delay = t
 	})
 }
 
+// WithKeepAlive changes how often NoOp is sent on connections to the DSP that have nothing else to send.
+// The core closes connections that it hasn't heard from in about 60 seconds, and a NoOp that isn't answered
+// means the connection is dead, so it is closed and opened again (logging on again if there are credentials)
+// before the next command needs it, as long as a command was sent within the TTL.
+// The default value is 20 seconds. An interval of 0 disables keepalives and reconnecting.
+func WithKeepAlive(t time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.keepAlive = t
+	})
+}
+
+// WithProtocol changes the protocol used to talk to the DSP.
+// The default value is ProtocolQRC.
+func WithProtocol(p Protocol) Option {
+	return optionFunc(func(o *options) {
+		o.protocol = p
+	})
+}
+
+// WithTransport changes how connections to the core are opened, e.g. to use a transport that isn't built in
+// or an in-memory connection in tests. It overrides WithProtocol.
+// The default value is nil, meaning that connections use the transport for the protocol.
+func WithTransport(dial DialFunc) Option {
+	return optionFunc(func(o *options) {
+		o.dial = dial
+	})
+}
+
+// WithTLSConfig changes how the core is verified when using ProtocolSecureWebSocket,
+// e.g. to trust the certificate authority that signed the core's certificate,
+// or to skip verification for cores that still have their self-signed certificate.
+// The default value is nil, meaning that the core is verified with the system's certificate authorities.
+func WithTLSConfig(c *tls.Config) Option {
+	return optionFunc(func(o *options) {
+		o.tlsConfig = c
+	})
+}
+
+// WithBackup makes the DSP one core of a redundant pair, with backup as the address of the other core.
+// Commands are only sent to whichever core is Active, and connections fail over to the other core
+// as soon as the one they are on reports that it is no longer Active.
+// The default value is "", meaning that the DSP is a single core.
+func WithBackup(backup string) Option {
+	return optionFunc(func(o *options) {
+		o.backup = backup
+	})
+}
+
 // WithLogger adds a logger to DSP.
 // DSP will log appropriate information about the underlying connection and the commands being sent.
 // The default value is nil, meaning that no logs are written.
@@ -49,3 +127,48 @@ func WithLogger(l *zap.Logger) Option {
 		o.logger = l
 	})
 }
+
+// WithCredentials sets how DSP gets the credentials to log on to the core.
+// Logon is sent on every new connection to the core.
+// The default value is nil, meaning that Logon is never sent.
+func WithCredentials(f CredentialsFunc) Option {
+	return optionFunc(func(o *options) {
+		o.credentials = f
+	})
+}
+
+// WithPollRate changes how often the core sends changes to the DSP's change group.
+// Controls read by Volumes and Mutes are added to the change group, so later reads are served from memory.
+// The default value is 250 milliseconds. A rate of 0 disables the change group, so every read is sent to the core.
+func WithPollRate(t time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.pollRate = t
+	})
+}
+
+// WithVolumeMode changes how volume levels are sent to and read from a block.
+// block is the name of the gain control on the core, e.g. "ZoneGain".
+// The default mode for every block is VolumeModeGain.
+func WithVolumeMode(block string, mode VolumeMode) Option {
+	return optionFunc(func(o *options) {
+		if o.volumeModes == nil {
+			o.volumeModes = make(map[string]VolumeMode)
+		}
+
+		o.volumeModes[block] = mode
+	})
+}
+
+// WithVolumeCurve changes how volume levels are converted to gain for a block.
+// block is the name of the gain control on the core, e.g. "ZoneGain".
+// A block of "" sets the curve for every block that doesn't have its own.
+// The default curve is DefaultVolumeCurve. Curves are not used by blocks in VolumeModePosition.
+func WithVolumeCurve(block string, curve VolumeCurve) Option {
+	return optionFunc(func(o *options) {
+		if o.curves == nil {
+			o.curves = make(map[string]VolumeCurve)
+		}
+
+		o.curves[block] = curve
+	})
+}
@@ -0,0 +1,321 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QSCLoopPlayerStartRequest is for the LoopPlayer.Start method
+type QSCLoopPlayerStartRequest struct {
+	BaseRequest
+	Params QSCLoopPlayerStartParams `json:"params"`
+}
+
+// QSCLoopPlayerStartParams is the parameters for the LoopPlayer.Start method
+type QSCLoopPlayerStartParams struct {
+	Name      string
+	Files     []QSCLoopPlayerFile
+	StartTime *float64 `json:",omitempty"`
+	Loop      bool
+	Log       bool
+}
+
+// QSCLoopPlayerFile is a file for a loop player to play, and the output it plays on
+type QSCLoopPlayerFile struct {
+	Name   string
+	Mode   string
+	Output int
+	Seek   float64 `json:",omitempty"`
+}
+
+// QSCLoopPlayerStopRequest is for the LoopPlayer.Stop and LoopPlayer.Cancel methods
+type QSCLoopPlayerStopRequest struct {
+	BaseRequest
+	Params QSCLoopPlayerStopParams `json:"params"`
+}
+
+// QSCLoopPlayerStopParams is the parameters for the LoopPlayer.Stop and LoopPlayer.Cancel methods
+type QSCLoopPlayerStopParams struct {
+	Name    string
+	Outputs []int
+	Log     bool
+}
+
+// LoopPlayerJob is what a loop player is asked to play.
+type LoopPlayerJob struct {
+	Files []LoopPlayerFile `json:"files"`
+
+	// StartTime is when the job starts, in seconds after midnight on the core's clock.
+	// If it isn't set, the job starts right away.
+	StartTime *float64 `json:"startTime,omitempty"`
+
+	Loop bool `json:"loop"`
+
+	// Log writes the job to the core's event log
+	Log bool `json:"log"`
+}
+
+// LoopPlayerFile is a file for a loop player to play.
+type LoopPlayerFile struct {
+	// Name is the path of the file on the core, e.g. "Audio/chime.wav"
+	Name string `json:"name"`
+
+	// Mode is "mono" or "stereo"; stereo files play on Output and the output after it
+	Mode string `json:"mode"`
+
+	Output int `json:"output"`
+
+	// Seek is how many seconds into the file to start playing
+	Seek float64 `json:"seek,omitempty"`
+}
+
+// LoopPlayerStopRequest is the body of a request to stop or cancel a loop player.
+type LoopPlayerStopRequest struct {
+	Outputs []int `json:"outputs"`
+	Log     bool  `json:"log"`
+}
+
+// PlayerStatus is the state of an audio or loop player, read from its component controls.
+type PlayerStatus struct {
+	Name    string `json:"name"`
+	Playing bool   `json:"playing"`
+
+	// Progress is how far through the current file the player is, from 0 to 1
+	Progress *float64 `json:"progress,omitempty"`
+	File     string   `json:"file,omitempty"`
+
+	// Outputs is the state of each output of a loop player
+	Outputs []PlayerOutputStatus `json:"outputs,omitempty"`
+
+	// Controls is every control of the player, for anything not summarized above
+	Controls []QSCControl `json:"controls"`
+}
+
+// PlayerOutputStatus is the state of a single output of a loop player.
+type PlayerOutputStatus struct {
+	Output   int      `json:"output"`
+	Playing  bool     `json:"playing"`
+	Progress *float64 `json:"progress,omitempty"`
+	File     string   `json:"file,omitempty"`
+}
+
+var _playerOutput = regexp.MustCompile(`^output\.(\d+)\.(.+)$`)
+
+func (d *DSP) GetGenericLoopPlayerStartRequest(ctx context.Context) QSCLoopPlayerStartRequest {
+	return QSCLoopPlayerStartRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: "LoopPlayer.Start"}, Params: QSCLoopPlayerStartParams{}}
+}
+
+func (d *DSP) GetGenericLoopPlayerStopRequest(ctx context.Context, method string) QSCLoopPlayerStopRequest {
+	return QSCLoopPlayerStopRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: method}, Params: QSCLoopPlayerStopParams{}}
+}
+
+func (dm *DeviceManager) HandlerStartLoopPlayer(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	var job LoopPlayerJob
+	if err := ctx.ShouldBindJSON(&job); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("starting loop player", zap.String("address", addr), zap.String("player", name), zap.Any("job", job))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := dsp.StartLoopPlayer(c, name, job); err != nil {
+		dm.Log.Error("unable to start loop player", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Started loop player", zap.String("address", addr), zap.String("player", name))
+	ctx.JSON(http.StatusOK, job)
+}
+
+func (dm *DeviceManager) HandlerStopLoopPlayer(ctx *gin.Context) {
+	dm.handleLoopPlayerStop(ctx, "LoopPlayer.Stop")
+}
+
+func (dm *DeviceManager) HandlerCancelLoopPlayer(ctx *gin.Context) {
+	dm.handleLoopPlayerStop(ctx, "LoopPlayer.Cancel")
+}
+
+func (dm *DeviceManager) handleLoopPlayerStop(ctx *gin.Context, method string) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	var req LoopPlayerStopRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		return
+	}
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("stopping loop player", zap.String("address", addr), zap.String("player", name), zap.String("method", method), zap.Ints("outputs", req.Outputs))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := dsp.stopLoopPlayer(c, method, name, req.Outputs, req.Log); err != nil {
+		dm.Log.Error("unable to stop loop player", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Stopped loop player", zap.String("address", addr), zap.String("player", name))
+	ctx.JSON(http.StatusOK, req)
+}
+
+func (dm *DeviceManager) HandlerGetPlayerStatus(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+
+	dsp := dm.CreateDSP(addr)
+	dm.Log.Debug("getting player status", zap.String("address", addr), zap.String("player", name))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := dsp.PlayerStatus(c, name)
+	if err != nil {
+		dm.Log.Error("unable to get player status", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Got player status", zap.String("address", addr), zap.String("player", name), zap.Bool("playing", status.Playing))
+	ctx.JSON(http.StatusOK, status)
+}
+
+// StartLoopPlayer starts a job on the named loop player.
+func (d *DSP) StartLoopPlayer(ctx context.Context, player string, job LoopPlayerJob) error {
+	if len(job.Files) == 0 {
+		return fmt.Errorf("%w: at least one file must be played", ErrInvalidParams)
+	}
+
+	req := d.GetGenericLoopPlayerStartRequest(ctx)
+	req.Params.Name = player
+	req.Params.StartTime = job.StartTime
+	req.Params.Loop = job.Loop
+	req.Params.Log = job.Log
+
+	for _, file := range job.Files {
+		switch {
+		case file.Name == "":
+			return fmt.Errorf("%w: every file needs a name", ErrInvalidParams)
+		case file.Output < 1:
+			return fmt.Errorf("%w: output of %s must be at least 1", ErrInvalidParams, file.Name)
+		case file.Seek < 0:
+			return fmt.Errorf("%w: seek of %s must not be negative", ErrInvalidParams, file.Name)
+		}
+
+		mode := file.Mode
+		switch mode {
+		case "":
+			mode = "mono"
+		case "mono", "stereo":
+		default:
+			return fmt.Errorf("%w: mode of %s must be mono or stereo", ErrInvalidParams, file.Name)
+		}
+
+		req.Params.Files = append(req.Params.Files, QSCLoopPlayerFile{Name: file.Name, Mode: mode, Output: file.Output, Seek: file.Seek})
+	}
+
+	d.log.Info("Starting loop player", zap.String("player", player), zap.Int("files", len(job.Files)), zap.Bool("loop", job.Loop))
+
+	_, err := d.client.Do(ctx, req)
+	return err
+}
+
+// StopLoopPlayer stops the jobs playing on outputs of the named loop player.
+func (d *DSP) StopLoopPlayer(ctx context.Context, player string, outputs []int) error {
+	return d.stopLoopPlayer(ctx, "LoopPlayer.Stop", player, outputs, false)
+}
+
+// CancelLoopPlayer cancels the jobs waiting to start on outputs of the named loop player.
+func (d *DSP) CancelLoopPlayer(ctx context.Context, player string, outputs []int) error {
+	return d.stopLoopPlayer(ctx, "LoopPlayer.Cancel", player, outputs, false)
+}
+
+func (d *DSP) stopLoopPlayer(ctx context.Context, method, player string, outputs []int, log bool) error {
+	if len(outputs) == 0 {
+		return fmt.Errorf("%w: at least one output is required", ErrInvalidParams)
+	}
+
+	req := d.GetGenericLoopPlayerStopRequest(ctx, method)
+	req.Params.Name = player
+	req.Params.Outputs = outputs
+	req.Params.Log = log
+
+	d.log.Info("Stopping loop player", zap.String("method", method), zap.String("player", player), zap.Ints("outputs", outputs))
+
+	_, err := d.client.Do(ctx, req)
+	return err
+}
+
+// PlayerStatus reads the controls of the named audio or loop player,
+// and summarizes whether it is playing, how far along it is, and which file it is playing.
+// Loop players also get a summary of each output.
+func (d *DSP) PlayerStatus(ctx context.Context, player string) (PlayerStatus, error) {
+	controls, err := d.ComponentControls(ctx, player)
+	if err != nil {
+		return PlayerStatus{}, err
+	}
+
+	status := PlayerStatus{
+		Name:     player,
+		Controls: controls,
+	}
+
+	outputs := make(map[int]*PlayerOutputStatus)
+	for i := range controls {
+		control := &controls[i]
+
+		if m := _playerOutput.FindStringSubmatch(control.Name); m != nil {
+			output, _ := strconv.Atoi(m[1])
+			if outputs[output] == nil {
+				outputs[output] = &PlayerOutputStatus{Output: output}
+			}
+
+			out := outputs[output]
+			summarizePlayerControl(m[2], control, &out.Playing, &out.Progress, &out.File)
+			status.Playing = status.Playing || out.Playing
+			continue
+		}
+
+		summarizePlayerControl(control.Name, control, &status.Playing, &status.Progress, &status.File)
+	}
+
+	for _, out := range outputs {
+		status.Outputs = append(status.Outputs, *out)
+	}
+
+	sort.Slice(status.Outputs, func(i, j int) bool {
+		return status.Outputs[i].Output < status.Outputs[j].Output
+	})
+
+	return status, nil
+}
+
+// summarizePlayerControl fills in the playing state, progress, or file from a player control.
+func summarizePlayerControl(name string, control *QSCControl, playing *bool, progress **float64, file *string) {
+	switch name {
+	case "playing":
+		*playing = *playing || control.Value != 0
+	case "progress":
+		*progress = &control.Position
+	case "filename", "file.name", "file":
+		*file = control.String
+	}
+}
@@ -0,0 +1,319 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// _standbyProbeInterval is how often the engine state of the core we aren't connected to is read.
+const _standbyProbeInterval = 30 * time.Second
+
+// CoreState is the last known engine state of a core in a redundant pair.
+type CoreState struct {
+	Address string
+	Active  bool
+
+	// State is the State from the core's engine status, or empty if it couldn't be read
+	State string
+	Error string `json:",omitempty"`
+}
+
+// redundantPair routes connections to whichever core of a redundant pair is Active.
+// Connections are opened to the core that was last Active, and only to the other core if that one isn't.
+// A connection is closed as soon as its core reports that it is no longer Active, or the other core
+// reports that it is, so that the client reconnects to the core that took over.
+type redundantPair struct {
+	addrs  [2]string
+	dialer DialFunc
+	log    *zap.Logger
+
+	// ctx stops the standby probe once the pair is closed
+	ctx       context.Context
+	cancel    context.CancelFunc
+	probeOnce sync.Once
+
+	mu     sync.Mutex
+	active string
+	states map[string]CoreState
+	conns  map[*memberTransport]struct{}
+}
+
+func newRedundantPair(primary, backup string, dial DialFunc, log *zap.Logger) *redundantPair {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &redundantPair{
+		addrs:  [2]string{primary, backup},
+		dialer: dial,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+		states: make(map[string]CoreState),
+		conns:  make(map[*memberTransport]struct{}),
+	}
+}
+
+// Close stops probing the standby core.
+func (p *redundantPair) Close() {
+	p.cancel()
+}
+
+// member is the result of connecting to a single core of the pair.
+type member struct {
+	addr   string
+	t      Transport
+	prompt []byte
+	state  string
+	err    error
+}
+
+// dial returns a connection to the Active core. The core that was last Active is tried first,
+// or the primary if neither has been yet, and the other core is only tried if that one isn't Active.
+// If neither says that it is Active, a core that isn't in standby is preferred, and then the first one tried.
+func (p *redundantPair) dial(ctx context.Context, _ string) (Transport, error) {
+	p.probeOnce.Do(func() {
+		go p.probeStandby(p.ctx)
+	})
+
+	p.mu.Lock()
+	order := p.addrs
+	if p.active == order[1] {
+		order[0], order[1] = order[1], order[0]
+	}
+	p.mu.Unlock()
+
+	var members []member
+	chosen := -1
+	for _, addr := range order {
+		m := p.connect(ctx, addr)
+		members = append(members, m)
+
+		if m.err != nil {
+			continue
+		}
+
+		if m.state == "Active" {
+			chosen = len(members) - 1
+			break
+		}
+
+		if chosen == -1 || (members[chosen].state == "Standby" && m.state != "Standby") {
+			chosen = len(members) - 1
+		}
+	}
+
+	for i, m := range members {
+		if i != chosen && m.t != nil {
+			m.t.Close()
+		}
+	}
+
+	if chosen == -1 {
+		return nil, fmt.Errorf("unable to connect to %s: %w; or to %s: %w", members[0].addr, members[0].err, members[1].addr, members[1].err)
+	}
+
+	m := members[chosen]
+	t := &memberTransport{Transport: m.t, addr: m.addr, pair: p, prompt: m.prompt}
+
+	p.mu.Lock()
+	if p.active != "" && p.active != m.addr {
+		p.log.Warn("Failed over to redundant core", zap.String("from", p.active), zap.String("to", m.addr))
+	}
+
+	p.active = m.addr
+	p.conns[t] = struct{}{}
+	p.mu.Unlock()
+
+	p.log.Info("Connected to redundant core", zap.String("active", m.addr), zap.String("state", m.state))
+	return t, nil
+}
+
+// connect opens a connection to addr and reads its engine status from the first frame.
+func (p *redundantPair) connect(ctx context.Context, addr string) member {
+	m := member{addr: addr}
+
+	m.t, m.err = p.dialer(ctx, addr)
+	if m.err != nil {
+		p.setState(addr, "", m.err)
+		return m
+	}
+
+	m.prompt, m.err = m.t.ReadFrame()
+	if m.err != nil {
+		m.t.Close()
+		m.t = nil
+		p.setState(addr, "", m.err)
+		return m
+	}
+
+	if status, ok := parseEngineStatus(m.prompt); ok {
+		m.state = status.State
+	}
+
+	p.setState(addr, m.state, nil)
+	return m
+}
+
+// probeStandby reads the engine state of the core that we aren't connected to every _standbyProbeInterval
+// until ctx is done, so that the state of both cores is known. If it has become Active while the core we are
+// connected to isn't, the connections are closed so that they reopen on it.
+func (p *redundantPair) probeStandby(ctx context.Context) {
+	ticker := time.NewTicker(_standbyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		active := p.active
+		p.mu.Unlock()
+
+		for _, addr := range p.addrs {
+			if addr == active {
+				continue
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			m := p.connect(probeCtx, addr)
+			cancel()
+
+			if m.t != nil {
+				m.t.Close()
+			}
+
+			if m.state == "Active" {
+				p.takenOver(addr)
+			}
+		}
+	}
+}
+
+// takenOver closes every connection to the other core of the pair after addr reports that it is Active,
+// unless the other core still says that it is Active too.
+func (p *redundantPair) takenOver(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == "" || p.active == addr || p.states[p.active].State == "Active" {
+		return
+	}
+
+	p.log.Warn("Redundant core took over", zap.String("active", addr), zap.String("previous", p.active))
+
+	// the connections reopen on the core that took over first
+	p.active = addr
+	for t := range p.conns {
+		t.Transport.Close()
+	}
+}
+
+func (p *redundantPair) setState(addr, state string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := CoreState{Address: addr, State: state}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	if prev, ok := p.states[addr]; ok && prev.State != s.State {
+		p.log.Info("Redundant core changed state", zap.String("address", addr), zap.String("from", prev.State), zap.String("to", s.State))
+	}
+
+	p.states[addr] = s
+}
+
+// States returns the last known state of each core, primary first.
+func (p *redundantPair) States() []CoreState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	states := make([]CoreState, 0, len(p.addrs))
+	for _, addr := range p.addrs {
+		s, ok := p.states[addr]
+		if !ok {
+			s = CoreState{Address: addr}
+		}
+
+		s.Active = addr == p.active
+		states = append(states, s)
+	}
+
+	return states
+}
+
+// Active returns the address of the core that connections are routed to.
+func (p *redundantPair) Active() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.active
+}
+
+// memberTransport is a connection to one core of a redundant pair.
+// It fails once the core reports that it is no longer Active.
+type memberTransport struct {
+	Transport
+	addr   string
+	pair   *redundantPair
+	prompt []byte
+	err    error
+}
+
+func (t *memberTransport) ReadFrame() ([]byte, error) {
+	if t.prompt != nil {
+		prompt := t.prompt
+		t.prompt = nil
+		return prompt, nil
+	}
+
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	buf, err := t.Transport.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	if status, ok := parseEngineStatus(buf); ok {
+		t.pair.setState(t.addr, status.State, nil)
+
+		// let the notification through before failing, so the DSP sees the new state
+		if status.State != "Active" {
+			t.err = fmt.Errorf("%s is now %s", t.addr, status.State)
+		}
+	}
+
+	return buf, nil
+}
+
+func (t *memberTransport) Close() error {
+	t.pair.mu.Lock()
+	delete(t.pair.conns, t)
+	t.pair.mu.Unlock()
+
+	return t.Transport.Close()
+}
+
+// parseEngineStatus returns the status in buf if it is an EngineStatus notification.
+func parseEngineStatus(buf []byte) (QSCStatusGetResult, bool) {
+	var f frame
+	if err := json.Unmarshal(buf, &f); err != nil || f.ID != nil || f.Method != "EngineStatus" {
+		return QSCStatusGetResult{}, false
+	}
+
+	var status QSCStatusGetResult
+	if err := json.Unmarshal(f.Params, &status); err != nil {
+		return QSCStatusGetResult{}, false
+	}
+
+	return status, true
+}
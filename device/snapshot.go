@@ -0,0 +1,170 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// QSCSnapshotRequest is for the Snapshot.Load and Snapshot.Save methods
+type QSCSnapshotRequest struct {
+	BaseRequest
+	Params QSCSnapshotParams `json:"params"`
+}
+
+// QSCSnapshotParams is the parameters for the Snapshot.Load and Snapshot.Save methods.
+// Name is the name of the snapshot bank, and Bank is the number of the snapshot in it.
+type QSCSnapshotParams struct {
+	Name string
+	Bank int
+
+	// Ramp is how many seconds the core takes to move controls to the loaded snapshot
+	Ramp float64 `json:",omitempty"`
+}
+
+// SnapshotResponse is returned after a snapshot is loaded or saved.
+type SnapshotResponse struct {
+	Bank   string `json:"bank"`
+	Number int    `json:"number"`
+}
+
+func (d *DSP) GetGenericSnapshotRequest(ctx context.Context, method string) QSCSnapshotRequest {
+	return QSCSnapshotRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: d.client.nextID(), Method: method}, Params: QSCSnapshotParams{}}
+}
+
+func (dm *DeviceManager) HandlerLoadSnapshot(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	bank := ctx.Param("bank")
+	dsp := dm.CreateDSP(addr)
+
+	number, err := parseSnapshotNumber(ctx.Param("number"))
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+
+	var ramp time.Duration
+	if r := ctx.Query("ramp"); r != "" {
+		ramp, err = time.ParseDuration(r)
+		if err != nil {
+			writeError(ctx, fmt.Errorf("%w: could not parse ramp: %w", ErrInvalidParams, err))
+			return
+		}
+	}
+
+	dm.Log.Debug("loading snapshot", zap.String("address", addr), zap.String("bank", bank), zap.Int("number", number), zap.Duration("ramp", ramp))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := dsp.LoadSnapshot(c, bank, number, ramp); err != nil {
+		dm.Log.Error("unable to load snapshot", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Loaded snapshot", zap.String("address", addr), zap.String("bank", bank), zap.Int("number", number))
+	ctx.JSON(http.StatusOK, SnapshotResponse{
+		Bank:   bank,
+		Number: number,
+	})
+}
+
+func (dm *DeviceManager) HandlerSaveSnapshot(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	bank := ctx.Param("bank")
+	dsp := dm.CreateDSP(addr)
+
+	number, err := parseSnapshotNumber(ctx.Param("number"))
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("saving snapshot", zap.String("address", addr), zap.String("bank", bank), zap.Int("number", number))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := dsp.SaveSnapshot(c, bank, number); err != nil {
+		dm.Log.Error("unable to save snapshot", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
+
+	dm.Log.Debug("Saved snapshot", zap.String("address", addr), zap.String("bank", bank), zap.Int("number", number))
+	ctx.JSON(http.StatusOK, SnapshotResponse{
+		Bank:   bank,
+		Number: number,
+	})
+}
+
+func parseSnapshotNumber(s string) (int, error) {
+	number, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: could not parse snapshot number: %w", ErrInvalidParams, err)
+	}
+
+	return number, nil
+}
+
+// validateSnapshot checks a snapshot bank and number before they are sent to the core.
+func validateSnapshot(bank string, number int) error {
+	switch {
+	case bank == "":
+		return fmt.Errorf("%w: snapshot bank is required", ErrInvalidParams)
+	case number < 1:
+		return fmt.Errorf("%w: snapshot number must be at least 1", ErrInvalidParams)
+	}
+
+	return nil
+}
+
+// LoadSnapshot recalls snapshot number from the named snapshot bank, moving controls to it over the ramp time.
+func (d *DSP) LoadSnapshot(ctx context.Context, bank string, number int, ramp time.Duration) error {
+	if err := validateSnapshot(bank, number); err != nil {
+		return err
+	}
+
+	if ramp < 0 {
+		return fmt.Errorf("%w: ramp must not be negative", ErrInvalidParams)
+	}
+
+	req := d.GetGenericSnapshotRequest(ctx, "Snapshot.Load")
+	req.Params.Name = bank
+	req.Params.Bank = number
+	req.Params.Ramp = ramp.Seconds()
+
+	d.log.Info("Loading snapshot", zap.String("bank", bank), zap.Int("number", number), zap.Duration("ramp", ramp))
+
+	return d.snapshot(ctx, req)
+}
+
+// SaveSnapshot stores the current state of the controls in the named snapshot bank as snapshot number.
+func (d *DSP) SaveSnapshot(ctx context.Context, bank string, number int) error {
+	if err := validateSnapshot(bank, number); err != nil {
+		return err
+	}
+
+	req := d.GetGenericSnapshotRequest(ctx, "Snapshot.Save")
+	req.Params.Name = bank
+	req.Params.Bank = number
+
+	d.log.Info("Saving snapshot", zap.String("bank", bank), zap.Int("number", number))
+
+	return d.snapshot(ctx, req)
+}
+
+func (d *DSP) snapshot(ctx context.Context, req QSCSnapshotRequest) error {
+	if _, err := d.client.Do(ctx, req); err != nil {
+		// the core reports a bank that doesn't exist as an unknown component
+		return fmt.Errorf("snapshot bank %s: %w", req.Params.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,574 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// SubscribeRequest is sent by websocket clients to choose which controls they receive changes for.
+// Each request adds to the controls the client is already subscribed to.
+type SubscribeRequest struct {
+	Controls []string `json:"controls"`
+
+	// Components is a map of component name to control names.
+	// If no control names are given, every control of the component is subscribed to.
+	Components map[string][]string `json:"components"`
+}
+
+// SubscribeMessage is sent to websocket clients whenever subscribed controls change.
+type SubscribeMessage struct {
+	Changes []QSCChange `json:"changes,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	// touch panels are served from other hosts, just like the cors policy allows
+	CheckOrigin: func(*http.Request) bool {
+		return true
+	},
+}
+
+func (dm *DeviceManager) HandlerSubscribe(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	dsp := dm.CreateDSP(addr)
+
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		dm.Log.Warn("unable to upgrade to websocket", zap.String("address", addr), zap.Error(err))
+		return
+	}
+	defer ws.Close()
+
+	dm.Log.Info("websocket client subscribed", zap.String("address", addr), zap.String("client", ws.RemoteAddr().String()))
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := dsp.Subscribe()
+	defer func() {
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer closeCancel()
+
+		if err := sub.Close(closeCtx); err != nil {
+			dm.Log.Warn("unable to close subscription", zap.String("address", addr), zap.Error(err))
+		}
+	}()
+
+	var writeMu sync.Mutex
+	write := func(msg SubscribeMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return ws.WriteJSON(msg)
+	}
+
+	go func() {
+		defer cancel()
+
+		for {
+			var req SubscribeRequest
+			if err := ws.ReadJSON(&req); err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					dm.Log.Warn("unable to read from websocket", zap.String("address", addr), zap.Error(err))
+				}
+
+				return
+			}
+
+			dm.Log.Debug("adding to subscription", zap.String("address", addr), zap.Strings("controls", req.Controls), zap.Any("components", req.Components))
+
+			addCtx, addCancel := context.WithTimeout(c, 5*time.Second)
+			err := sub.Add(addCtx, req.Controls, req.Components)
+			addCancel()
+
+			if err != nil {
+				dm.Log.Warn("unable to add to subscription", zap.String("address", addr), zap.Error(err))
+				if err := write(SubscribeMessage{Error: err.Error()}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		changes, err := sub.Next(c)
+		if err != nil {
+			break
+		}
+
+		if err := write(SubscribeMessage{Changes: changes}); err != nil {
+			dm.Log.Warn("unable to write to websocket", zap.String("address", addr), zap.Error(err))
+			break
+		}
+	}
+
+	dm.Log.Info("websocket client unsubscribed", zap.String("address", addr), zap.String("client", ws.RemoteAddr().String()))
+}
+
+// subscriptions tracks which controls are wanted in the change group and who wants them.
+// Every control is only added to the change group once, no matter how many subscriptions want it,
+// and it is removed once nothing wants it anymore.
+type subscriptions struct {
+	// refsMu guards the reference counts and is held while the change group is updated
+	refsMu sync.Mutex
+	refs   map[controlKey]int
+
+	// watchMu guards when each watched control was last read, the timer that unwatches the ones that aren't read anymore,
+	// and whether the DSP has been closed, after which nothing is watched
+	watchMu sync.Mutex
+	watched map[controlKey]time.Time
+	expiry  *time.Timer
+	closed  bool
+
+	// subsMu guards the open subscriptions, which the change group publishes to
+	subsMu sync.RWMutex
+	subs   map[*Subscription]struct{}
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		refs:    make(map[controlKey]int),
+		watched: make(map[controlKey]time.Time),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// close stops unwatching controls, and keeps any more from being watched.
+func (s *subscriptions) close() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	s.closed = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+}
+
+// Subscription receives the changes to a set of controls.
+// Changes that arrive before they are read are merged, so only the latest value of each control is returned.
+type Subscription struct {
+	dsp *DSP
+
+	// addMu keeps Add and Close from running at the same time
+	addMu sync.Mutex
+
+	mu      sync.Mutex
+	keys    map[controlKey]struct{}
+	pending map[controlKey]QSCChange
+	closed  bool
+
+	ready chan struct{}
+	done  chan struct{}
+}
+
+// Subscribe opens a subscription with no controls. Add controls to it with Add.
+// The subscription must be closed when it is no longer needed.
+func (d *DSP) Subscribe() *Subscription {
+	sub := &Subscription{
+		dsp:     d,
+		keys:    make(map[controlKey]struct{}),
+		pending: make(map[controlKey]QSCChange),
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	d.subs.subsMu.Lock()
+	d.subs.subs[sub] = struct{}{}
+	d.subs.subsMu.Unlock()
+
+	return sub
+}
+
+// Add subscribes to named controls and to controls of components.
+// components is a map of component name to control names; if no control names are given,
+// every control of the component is subscribed to.
+// The current value of each control is sent as soon as it is known.
+func (s *Subscription) Add(ctx context.Context, controls []string, components map[string][]string) error {
+	if s.dsp.pollRate <= 0 {
+		return fmt.Errorf("%w: change groups are disabled", ErrInvalidParams)
+	}
+
+	var keys []controlKey
+	for _, name := range controls {
+		keys = append(keys, controlKey{name: name})
+	}
+
+	for component, names := range components {
+		if len(names) == 0 {
+			all, err := s.dsp.ComponentControls(ctx, component)
+			if err != nil {
+				return err
+			}
+
+			for _, control := range all {
+				names = append(names, control.Name)
+			}
+		}
+
+		for _, name := range names {
+			keys = append(keys, controlKey{component: component, name: name})
+		}
+	}
+
+	s.addMu.Lock()
+	defer s.addMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription is closed")
+	}
+
+	// the keys are subscribed to before they are added to the change group,
+	// so that changes the core sends as soon as they are added aren't missed
+	var toAdd []controlKey
+	for _, key := range keys {
+		if _, ok := s.keys[key]; !ok {
+			s.keys[key] = struct{}{}
+			toAdd = append(toAdd, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.dsp.retain(ctx, toAdd); err != nil {
+		s.mu.Lock()
+		for _, key := range toAdd {
+			delete(s.keys, key)
+			delete(s.pending, key)
+		}
+		s.mu.Unlock()
+
+		return err
+	}
+
+	s.mu.Lock()
+	for _, key := range toAdd {
+		if _, ok := s.pending[key]; ok {
+			continue
+		}
+
+		if val, ok := s.dsp.changes.Value(key.component, key.name); ok {
+			s.pending[key] = val
+		}
+	}
+	s.mu.Unlock()
+
+	s.signal()
+	return nil
+}
+
+// Next waits for changes to the subscribed controls.
+func (s *Subscription) Next(ctx context.Context) ([]QSCChange, error) {
+	for {
+		s.mu.Lock()
+		if len(s.pending) > 0 {
+			changes := make([]QSCChange, 0, len(s.pending))
+			for key, change := range s.pending {
+				changes = append(changes, change)
+				delete(s.pending, key)
+			}
+
+			s.mu.Unlock()
+			return changes, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.ready:
+		case <-s.done:
+			return nil, fmt.Errorf("subscription is closed")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close ends the subscription, and removes its controls from the change group if nothing else wants them.
+func (s *Subscription) Close(ctx context.Context) error {
+	s.addMu.Lock()
+	defer s.addMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	close(s.done)
+
+	keys := make([]controlKey, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	s.dsp.subs.subsMu.Lock()
+	delete(s.dsp.subs.subs, s)
+	s.dsp.subs.subsMu.Unlock()
+
+	return s.dsp.release(ctx, keys)
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// publish sends changes from the change group to every subscription that wants them.
+func (d *DSP) publish(changes []QSCChange) {
+	for _, change := range changes {
+		d.events.publish(EventControl, change)
+	}
+
+	d.subs.subsMu.RLock()
+	defer d.subs.subsMu.RUnlock()
+
+	for sub := range d.subs.subs {
+		sub.mu.Lock()
+		wanted := false
+		for _, change := range changes {
+			key := controlKey{component: change.Component, name: change.Name}
+			if _, ok := sub.keys[key]; ok {
+				sub.pending[key] = change
+				wanted = true
+			}
+		}
+		sub.mu.Unlock()
+
+		if wanted {
+			sub.signal()
+		}
+	}
+}
+
+// retain adds a reference to each control, adding it to the change group if it is the first one.
+// If any of them can't be added, the ones that were are removed again, and no references are added.
+func (d *DSP) retain(ctx context.Context, keys []controlKey) error {
+	d.subs.refsMu.Lock()
+	defer d.subs.refsMu.Unlock()
+
+	var controls []string
+	components := make(map[string][]string)
+	for _, key := range keys {
+		if d.subs.refs[key] > 0 {
+			continue
+		}
+
+		if key.component == "" {
+			controls = append(controls, key.name)
+		} else {
+			components[key.component] = append(components[key.component], key.name)
+		}
+	}
+
+	if len(controls) > 0 {
+		if err := d.changes.AddControl(ctx, controls...); err != nil {
+			return err
+		}
+	}
+
+	added := make(map[string][]string)
+	for component, names := range components {
+		if err := d.changes.AddComponentControl(ctx, component, names...); err != nil {
+			d.unretain(controls, added)
+			return err
+		}
+
+		added[component] = names
+	}
+
+	for _, key := range keys {
+		d.subs.refs[key]++
+	}
+
+	return nil
+}
+
+// unretain removes controls that retain added before it failed.
+// The change group forgets them even if the core can't be told, so a fresh context is used
+// in case the one retain was given is what caused it to fail.
+func (d *DSP) unretain(controls []string, components map[string][]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if len(controls) > 0 {
+		if err := d.changes.Remove(ctx, controls...); err != nil {
+			d.log.Warn("unable to remove controls after failing to add others", zap.Strings("controls", controls), zap.Error(err))
+		}
+	}
+
+	for component, names := range components {
+		if err := d.changes.RemoveComponentControl(ctx, component, names...); err != nil {
+			d.log.Warn("unable to remove component controls after failing to add others", zap.String("component", component), zap.Strings("controls", names), zap.Error(err))
+		}
+	}
+}
+
+// release removes a reference to each control, removing it from the change group if it was the last one.
+// Every control is released even if some can't be removed from the change group; the errors are joined.
+func (d *DSP) release(ctx context.Context, keys []controlKey) error {
+	d.subs.refsMu.Lock()
+	defer d.subs.refsMu.Unlock()
+
+	var controls []string
+	components := make(map[string][]string)
+	for _, key := range keys {
+		d.subs.refs[key]--
+		if d.subs.refs[key] > 0 {
+			continue
+		}
+
+		delete(d.subs.refs, key)
+
+		if key.component == "" {
+			controls = append(controls, key.name)
+		} else {
+			components[key.component] = append(components[key.component], key.name)
+		}
+	}
+
+	var errs []error
+	if len(controls) > 0 {
+		if err := d.changes.Remove(ctx, controls...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for component, names := range components {
+		if err := d.changes.RemoveComponentControl(ctx, component, names...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// watch keeps a reference to named controls in the background, so that they stay in the change group
+// and later reads of them are served from memory. Controls that are already watched are marked as read,
+// and a control that isn't read for the ttl is released again, so that unused controls leave the change group.
+func (d *DSP) watch(names ...string) {
+	if d.pollRate <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	d.subs.watchMu.Lock()
+	if d.subs.closed {
+		d.subs.watchMu.Unlock()
+		return
+	}
+
+	var keys []controlKey
+	for _, name := range names {
+		key := controlKey{name: name}
+		if _, ok := d.subs.watched[key]; ok {
+			d.subs.watched[key] = now
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+	d.subs.watchMu.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := d.retain(ctx, keys); err != nil {
+			d.log.Warn("unable to watch controls", zap.Strings("controls", names), zap.Error(err))
+			return
+		}
+
+		d.subs.watchMu.Lock()
+		var dups []controlKey
+		for _, key := range keys {
+			if _, ok := d.subs.watched[key]; ok || d.subs.closed {
+				// it was watched by someone else at the same time, or the DSP was closed while it was being added
+				dups = append(dups, key)
+				continue
+			}
+
+			d.subs.watched[key] = time.Now()
+		}
+
+		if d.subs.expiry == nil && d.watchTTL > 0 && !d.subs.closed {
+			d.subs.expiry = time.AfterFunc(d.watchTTL, d.expireWatches)
+		}
+		d.subs.watchMu.Unlock()
+
+		if len(dups) > 0 {
+			if err := d.release(ctx, dups); err != nil {
+				d.log.Warn("unable to release controls that aren't watched", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// expireWatches releases the watched controls that haven't been read for the ttl,
+// and runs again when the next one would expire.
+func (d *DSP) expireWatches() {
+	d.subs.watchMu.Lock()
+	if d.subs.closed {
+		// the timer fired while the DSP was being closed
+		d.subs.watchMu.Unlock()
+		return
+	}
+
+	var expired []controlKey
+	var next time.Duration
+	for key, read := range d.subs.watched {
+		left := d.watchTTL - time.Since(read)
+		if left <= 0 {
+			expired = append(expired, key)
+			delete(d.subs.watched, key)
+			continue
+		}
+
+		if next == 0 || left < next {
+			next = left
+		}
+	}
+
+	if len(d.subs.watched) > 0 {
+		d.subs.expiry.Reset(next)
+	} else {
+		d.subs.expiry = nil
+	}
+	d.subs.watchMu.Unlock()
+
+	if len(expired) == 0 {
+		return
+	}
+
+	names := make([]string, len(expired))
+	for i, key := range expired {
+		names[i] = key.name
+	}
+
+	d.log.Info("Unwatching controls that haven't been read", zap.Strings("controls", names))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := d.release(ctx, expired); err != nil {
+		d.log.Warn("unable to unwatch controls", zap.Strings("controls", names), zap.Error(err))
+	}
+}
@@ -0,0 +1,135 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Protocol is the protocol used to talk to a core.
+type Protocol string
+
+const (
+	// ProtocolQRC is the Q-SYS Remote Control protocol on port 1710, which supports everything.
+	ProtocolQRC Protocol = "qrc"
+
+	// ProtocolECP is the External Control Protocol on port 1702, which only supports named controls,
+	// change groups of named controls, and the engine status.
+	ProtocolECP Protocol = "ecp"
+
+	// ProtocolWebSocket is QRC over a WebSocket, which newer cores support.
+	ProtocolWebSocket Protocol = "ws"
+
+	// ProtocolSecureWebSocket is QRC over a WebSocket protected by TLS.
+	ProtocolSecureWebSocket Protocol = "wss"
+)
+
+// UnmarshalText only accepts the known protocols.
+func (p *Protocol) UnmarshalText(text []byte) error {
+	switch protocol := Protocol(text); protocol {
+	case ProtocolQRC, ProtocolECP, ProtocolWebSocket, ProtocolSecureWebSocket:
+		*p = protocol
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q", text)
+	}
+}
+
+// Transport carries QRC JSON-RPC frames between a DSP and a single connection to a core.
+// ReadFrame is only called by one goroutine at a time, and so is WriteFrame.
+type Transport interface {
+	// ReadFrame returns the next frame from the core, without its delimiter.
+	// The first frame is the one the core sends as soon as the connection is opened,
+	// which is usually an EngineStatus notification, or an empty frame if there isn't one.
+	// It returns an error once the connection has failed or been closed.
+	ReadFrame() ([]byte, error)
+
+	// WriteFrame sends a single frame to the core before deadline.
+	WriteFrame(frame []byte, deadline time.Time) error
+
+	// RemoteAddr is the address of the core.
+	RemoteAddr() net.Addr
+
+	// Close closes the connection, and makes ReadFrame return an error.
+	Close() error
+}
+
+// DialFunc opens a Transport to the core at addr.
+type DialFunc func(ctx context.Context, addr string) (Transport, error)
+
+// tcpTransport is QRC over TCP port 1710, where every frame ends with a NUL.
+type tcpTransport struct {
+	net.Conn
+	reader *bufio.Reader
+	prompt []byte
+}
+
+func dialTCP(ctx context.Context, addr string) (Transport, error) {
+	dial := net.Dialer{}
+	conn, err := dial.DialContext(ctx, "tcp", addr+":1710")
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	conn.SetDeadline(deadline)
+
+	// the core sends a NUL terminated frame as soon as we connect
+	t := &tcpTransport{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	t.prompt, err = t.readFrame()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to read new connection prompt: %w", err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return t, nil
+}
+
+func (t *tcpTransport) ReadFrame() ([]byte, error) {
+	if t.prompt != nil {
+		prompt := t.prompt
+		t.prompt = nil
+		return prompt, nil
+	}
+
+	return t.readFrame()
+}
+
+func (t *tcpTransport) readFrame() ([]byte, error) {
+	buf, err := t.reader.ReadBytes(0x00)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf, "\x00"), nil
+}
+
+func (t *tcpTransport) WriteFrame(frame []byte, deadline time.Time) error {
+	t.SetWriteDeadline(deadline)
+
+	buf := make([]byte, 0, len(frame)+1)
+	buf = append(buf, frame...)
+	buf = append(buf, 0x00)
+
+	n, err := t.Write(buf)
+	switch {
+	case err != nil:
+		return err
+	case n != len(buf):
+		return fmt.Errorf("wrote %v/%v bytes", n, len(buf))
+	}
+
+	return nil
+}
@@ -1,7 +1,6 @@
 package device
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,14 +8,37 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/byuoitav/common/status"
-	"github.com/byuoitav/connpool"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// VolumeMode is how a volume level from 0 to 100 is sent to and read from a block.
+type VolumeMode string
+
+const (
+	// VolumeModeGain converts the level to a gain in dB, and sets the control's Value.
+	VolumeModeGain VolumeMode = "gain"
+
+	// VolumeModePosition maps the level directly to the control's Position, from 0.0 to 1.0,
+	// so the level follows the same taper as the fader on the core.
+	VolumeModePosition VolumeMode = "position"
+)
+
+// UnmarshalText only accepts the known volume modes.
+func (m *VolumeMode) UnmarshalText(text []byte) error {
+	switch mode := VolumeMode(text); mode {
+	case VolumeModeGain, VolumeModePosition:
+		*m = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown volume mode %q", text)
+	}
+}
+
 func (dm *DeviceManager) HandlerGetVolume(ctx *gin.Context) {
 	addr := ctx.Param("address")
 	name := ctx.Param("name")
@@ -31,7 +53,7 @@ func (dm *DeviceManager) HandlerGetVolume(ctx *gin.Context) {
 	vols, err := dsp.Volumes(c, []string{name})
 	if err != nil {
 		dm.Log.Error("unable to get volumes", zap.Error(err))
-		ctx.String(http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 
@@ -40,7 +62,7 @@ func (dm *DeviceManager) HandlerGetVolume(ctx *gin.Context) {
 	vol, ok := vols[name]
 	if !ok {
 		dm.Log.Error("invalid name requested", zap.String("name", name))
-		ctx.String(http.StatusBadRequest, "invalid name")
+		writeError(ctx, fmt.Errorf("%w: %s", ErrInvalidControl, name))
 		return
 	}
 
@@ -57,7 +79,7 @@ func (dm *DeviceManager) HandlerSetVolume(ctx *gin.Context) {
 
 	vol, err := strconv.Atoi(ctx.Param("level"))
 	if err != nil {
-		ctx.String(http.StatusBadRequest, "could not parse volume level")
+		writeError(ctx, fmt.Errorf("%w: could not parse volume level: %w", ErrInvalidParams, err))
 		return
 	}
 
@@ -69,7 +91,7 @@ func (dm *DeviceManager) HandlerSetVolume(ctx *gin.Context) {
 	err = dsp.SetVolume(c, name, vol)
 	if err != nil {
 		dm.Log.Error("unable to set volume", zap.Error(err))
-		ctx.String(http.StatusInternalServerError, err.Error())
+		writeError(ctx, err)
 		return
 	}
 
@@ -79,136 +101,203 @@ func (dm *DeviceManager) HandlerSetVolume(ctx *gin.Context) {
 	})
 }
 
-func (d *DSP) Volumes(ctx context.Context, blocks []string) (map[string]int, error) {
-	toReturn := make(map[string]int)
+func (dm *DeviceManager) HandlerFadeVolume(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+	name += "Gain"
+	dsp := dm.CreateDSP(addr)
 
-	for i, block := range blocks {
-		req := d.GetGenericGetStatusRequest(ctx)
-		req.Params = append(req.Params, block)
+	vol, err := strconv.Atoi(ctx.Param("level"))
+	if err != nil {
+		writeError(ctx, fmt.Errorf("%w: could not parse volume level: %w", ErrInvalidParams, err))
+		return
+	}
 
-		qscResp := QSCGetStatusResponse{}
+	duration, err := time.ParseDuration(ctx.DefaultQuery("duration", "1s"))
+	switch {
+	case err != nil:
+		writeError(ctx, fmt.Errorf("%w: could not parse duration: %w", ErrInvalidParams, err))
+		return
+	case duration < 0:
+		writeError(ctx, fmt.Errorf("%w: duration must not be negative", ErrInvalidParams))
+		return
+	}
 
-		toSend, err := json.Marshal(req)
-		if err != nil {
-			return toReturn, err
-		}
-		toSend = append(toSend, 0x00)
+	dm.Log.Debug("fading volume", zap.String("name", name), zap.Int("volume", vol), zap.Duration("duration", duration))
 
-		var resp []byte
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
 
-		err = d.pool.Do(ctx, func(conn connpool.Conn) error {
-			d.log.Info("Getting volume on %v", zap.String("block", block))
-			conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	err = dsp.RampVolume(c, name, vol, duration)
+	if err != nil {
+		dm.Log.Error("unable to fade volume", zap.Error(err))
+		writeError(ctx, err)
+		return
+	}
 
-			n, err := conn.Write(toSend)
-			switch {
-			case err != nil:
-				return fmt.Errorf("unable to write command to get volume for block %v: %v", block, err)
-			case n != len(toSend):
-				return fmt.Errorf("unable to write command to get volume for block %v: wrote %v/%v bytes", block, n, len(toSend))
-			}
+	dm.Log.Debug("Started volume fade", zap.String("address", addr))
+	ctx.JSON(http.StatusOK, status.Volume{
+		Volume: vol,
+	})
+}
 
-			deadline, ok := ctx.Deadline()
-			if !ok {
-				return fmt.Errorf("no deadline set")
-			}
+// BlockStatus is the volume and mute state of a block.
+type BlockStatus struct {
+	Volume int  `json:"volume"`
+	Muted  bool `json:"muted"`
+}
+
+func (dm *DeviceManager) HandlerGetVolumes(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	dsp := dm.CreateDSP(addr)
 
-			resp, err = conn.ReadUntil(byte('\x00'), deadline)
-			if err != nil {
-				return fmt.Errorf("unable to read response: %w", err)
+	var names []string
+	for _, n := range ctx.QueryArray("names") {
+		for _, name := range strings.Split(n, ",") {
+			if name != "" {
+				names = append(names, name)
 			}
+		}
+	}
 
-			d.log.Debug("Got response: %v", zap.Any("response", resp))
-			fmt.Printf("resp: %s\n", resp)
+	if len(names) == 0 {
+		writeError(ctx, fmt.Errorf("%w: at least one name is required", ErrInvalidParams))
+		return
+	}
 
-			return nil
-		})
-		if err != nil {
-			return toReturn, err
-		}
-		resp = bytes.Trim(resp, "\x00")
-		err = json.Unmarshal(resp, &qscResp)
-		if err != nil {
-			d.log.Error(err.Error())
-			return toReturn, fmt.Errorf("error unmarshaling response: %v", err)
-		}
+	gains := make([]string, len(names))
+	mutes := make([]string, len(names))
+	for i, name := range names {
+		gains[i] = name + "Gain"
+		mutes[i] = name + "Mute"
+	}
 
-		d.log.Debug(fmt.Sprintf("[QSC-Communication] Response received: %+v\n", qscResp))
+	dm.Log.Debug("getting volumes and mutes", zap.String("address", addr), zap.Strings("names", names))
 
-		//get the volume out of the dsp and run it through our equation to reverse it
-		found := false
-		for _, res := range qscResp.Result {
-			if res.Name == block {
-				toReturn[blocks[i]] = d.DbToVolumeLevel(ctx, res.Value)
-				found = true
-				break
-			}
-		}
-		if found {
-			continue
-		}
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
 
-		return toReturn, errors.New("[QSC-Communication] No value returned with the name matching the requested state")
+	vols, muted, err := dsp.Levels(c, gains, mutes)
+	if err != nil {
+		dm.Log.Error("unable to get volumes and mutes", zap.String("address", addr), zap.Error(err))
+		writeError(ctx, err)
+		return
 	}
 
-	return toReturn, nil
-}
+	blocks := make(map[string]BlockStatus, len(names))
+	for i, name := range names {
+		blocks[name] = BlockStatus{
+			Volume: vols[gains[i]],
+			Muted:  muted[mutes[i]],
+		}
+	}
 
-func (d *DSP) SetVolume(ctx context.Context, block string, volume int) error {
+	dm.Log.Debug("Got volumes and mutes", zap.String("address", addr), zap.Int("count", len(blocks)))
+	ctx.JSON(http.StatusOK, blocks)
+}
 
-	d.log.Debug(fmt.Sprintf("got: %v", volume))
-	req := d.GetGenericSetStatusRequest(ctx)
-	req.Params.Name = block
+func (dm *DeviceManager) HandlerStepVolume(ctx *gin.Context) {
+	addr := ctx.Param("address")
+	name := ctx.Param("name")
+	name += "Gain"
+	dsp := dm.CreateDSP(addr)
 
-	if volume == 0 {
-		req.Params.Value = -100
-	} else {
-		//do the logarithmic magic
-		req.Params.Value = d.VolToDb(ctx, volume)
+	delta, err := strconv.Atoi(ctx.Param("delta"))
+	if err != nil {
+		writeError(ctx, fmt.Errorf("%w: could not parse volume step: %w", ErrInvalidParams, err))
+		return
 	}
-	d.log.Debug(fmt.Sprintf("sending: %v", req.Params.Value))
 
-	toSend, err := json.Marshal(req)
+	dm.Log.Debug("stepping volume", zap.String("name", name), zap.Int("delta", delta))
+
+	c, cancel := context.WithTimeout(ctx.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	vol, err := dsp.StepVolume(c, name, delta)
 	if err != nil {
-		return err
+		dm.Log.Error("unable to step volume", zap.Error(err))
+		writeError(ctx, err)
+		return
 	}
-	toSend = append(toSend, 0x00)
 
-	var resp []byte
-	err = d.pool.Do(ctx, func(conn connpool.Conn) error {
-		d.log.Info("setting volume on %v to %v", zap.String("block", block), zap.Int("level", volume))
+	dm.Log.Debug("Stepped volume", zap.String("address", addr), zap.Int("volume", vol))
+	ctx.JSON(http.StatusOK, status.Volume{
+		Volume: vol,
+	})
+}
 
-		conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+func (d *DSP) Volumes(ctx context.Context, blocks []string) (map[string]int, error) {
+	vols, _, err := d.Levels(ctx, blocks, nil)
+	return vols, err
+}
 
-		n, err := conn.Write(toSend)
-		switch {
-		case err != nil:
-			return fmt.Errorf("unable to write command to set volume: %v", err)
-		case n != len(toSend):
-			return fmt.Errorf("unable to write command to set volume: wrote %v/%v bytes", n, len(toSend))
-		}
+// Levels reads the volume of each gain block and the mute state of each mute block.
+// Blocks that are in the change group are read from memory, and every other block is read with a single Control.Get.
+func (d *DSP) Levels(ctx context.Context, gains, mutes []string) (map[string]int, map[string]bool, error) {
+	names := make([]string, 0, len(gains)+len(mutes))
+	names = append(names, gains...)
+	names = append(names, mutes...)
 
-		deadline, ok := ctx.Deadline()
-		if !ok {
-			return fmt.Errorf("no deadline set")
-		}
+	d.log.Info("Getting levels", zap.Strings("gains", gains), zap.Strings("mutes", mutes))
 
-		resp, err = conn.ReadUntil('\x00', deadline)
-		if err != nil {
-			return fmt.Errorf("unable to read response: %w", err)
+	vals, err := d.readControls(ctx, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vols := make(map[string]int)
+	for _, block := range gains {
+		val := vals[block]
+		vols[block] = d.volumeLevel(ctx, block, val.Value, val.Position)
+	}
+
+	muted := make(map[string]bool)
+	for _, block := range mutes {
+		val := vals[block]
+		if val.Value != 1.0 && val.Value != 0.0 {
+			return nil, nil, fmt.Errorf("[QSC-Communication] Invalid mute value received for %s: %v", block, val.Value)
 		}
 
-		d.log.Debug("Got response: %v", zap.Any("response", resp))
+		muted[block] = val.Value == 1.0
+	}
 
-		return nil
-	})
+	return vols, muted, nil
+}
+
+func (d *DSP) SetVolume(ctx context.Context, block string, volume int) error {
+	return d.RampVolume(ctx, block, volume, 0)
+}
+
+// RampVolume fades a block to volume over the ramp time, the same way as RampControl.
+func (d *DSP) RampVolume(ctx context.Context, block string, volume int, ramp time.Duration) error {
+	d.log.Debug(fmt.Sprintf("got: %v", volume))
+	if volume < 0 || volume > 100 {
+		return fmt.Errorf("%w: volume must be between 0 and 100", ErrInvalidParams)
+	}
+
+	req := d.GetGenericSetStatusRequest(ctx)
+	req.Params.Name = block
+	req.Params.Ramp = ramp.Seconds()
+
+	mode := d.volumeMode(block)
+	switch {
+	case mode == VolumeModePosition:
+		position := float64(volume) / 100
+		req.Params.Position = &position
+	default:
+		req.Params.Value = d.volumeCurve(block).Gain(volume)
+	}
+	d.log.Debug(fmt.Sprintf("sending: %+v", req.Params))
+
+	d.log.Info("setting volume", zap.String("block", block), zap.Int("level", volume), zap.String("mode", string(mode)), zap.Duration("ramp", ramp))
+
+	resp, err := d.client.Do(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	//we need to unmarshal our response, parse it for the value we care about, then role with it from there
 	qscResp := QSCSetStatusResponse{}
-	resp = bytes.Trim(resp, "\x00")
 	err = json.Unmarshal(resp, &qscResp)
 	if err != nil {
 		d.log.Error(err.Error())
@@ -220,9 +309,68 @@ func (d *DSP) SetVolume(ctx context.Context, block string, volume int) error {
 		return errors.New(errmsg)
 	}
 
+	// ramping controls are left for the change group to follow, see RampControl
+	if ramp <= 0 {
+		if req.Params.Position != nil {
+			d.changes.setPosition("", block, *req.Params.Position)
+		} else {
+			d.changes.set("", block, req.Params.Value)
+		}
+	}
+
 	return nil
 }
 
+// StepVolume changes the volume of block by delta, keeping it between 0 and 100, and returns the new volume.
+// Steps of the same block are done one at a time, so each one starts from the volume the last one set.
+func (d *DSP) StepVolume(ctx context.Context, block string, delta int) (int, error) {
+	unlock := d.locks.lock(block)
+	defer unlock()
+
+	vols, err := d.Volumes(ctx, []string{block})
+	if err != nil {
+		return 0, err
+	}
+
+	volume := clampLevel(vols[block] + delta)
+	if err := d.SetVolume(ctx, block, volume); err != nil {
+		return 0, err
+	}
+
+	return volume, nil
+}
+
+// volumeMode returns how volume levels are sent to block.
+func (d *DSP) volumeMode(block string) VolumeMode {
+	if mode, ok := d.volumeModes[block]; ok {
+		return mode
+	}
+
+	return VolumeModeGain
+}
+
+// volumeLevel converts the state of block to a volume level from 0 to 100.
+func (d *DSP) volumeLevel(ctx context.Context, block string, value, position float64) int {
+	if d.volumeMode(block) == VolumeModePosition {
+		return int(math.Round(position * 100))
+	}
+
+	return d.volumeCurve(block).Level(value)
+}
+
+// volumeCurve returns the curve used to convert volume levels to gain for block.
+func (d *DSP) volumeCurve(block string) VolumeCurve {
+	if curve, ok := d.curves[block]; ok {
+		return curve
+	}
+
+	if curve, ok := d.curves[""]; ok {
+		return curve
+	}
+
+	return DefaultVolumeCurve
+}
+
 func (d *DSP) DbToVolumeLevel(ctx context.Context, level float64) int {
 	return int(math.Pow(10, (level/20)) * 100)
 }
@@ -0,0 +1,133 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// _qrcWebSocketPath is where cores serve QRC over WebSocket.
+const _qrcWebSocketPath = "/qrc-public-api/v0"
+
+// wsTransport is QRC over a WebSocket, where every frame is a single text message.
+type wsTransport struct {
+	conn *websocket.Conn
+
+	// queued holds frames read before the connection finished opening
+	queued [][]byte
+}
+
+// dialWebSocket returns a DialFunc that opens QRC WebSocket connections, over TLS if secure is true.
+// tlsConfig is only used when secure is true; nil verifies the core with the system's certificate authorities.
+func dialWebSocket(secure bool, tlsConfig *tls.Config) DialFunc {
+	return func(ctx context.Context, addr string) (Transport, error) {
+		u := url.URL{Scheme: "ws", Host: addr, Path: _qrcWebSocketPath}
+		if secure {
+			u.Scheme = "wss"
+		}
+
+		dialer := websocket.Dialer{
+			TLSClientConfig:  tlsConfig,
+			HandshakeTimeout: 10 * time.Second,
+		}
+
+		conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(5 * time.Second)
+		}
+
+		conn.SetReadDeadline(deadline)
+
+		t := &wsTransport{conn: conn}
+		if err := t.readEngineStatus(deadline); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to read engine status: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Time{})
+		return t, nil
+	}
+}
+
+// readEngineStatus asks for the engine status, and queues it as the first frame as if the core had sent it
+// when we connected, the way it does over TCP. Frames read before the response are queued behind it.
+func (t *wsTransport) readEngineStatus(deadline time.Time) error {
+	// ids from the client start at 1, so 0 is never used by anything else
+	req := QSCStatusGetRequest{BaseRequest: BaseRequest{JSONRPC: "2.0", ID: 0, Method: "StatusGet"}, Params: 0}
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if err := t.WriteFrame(buf, deadline); err != nil {
+		return err
+	}
+
+	var queued [][]byte
+	for {
+		_, msg, err := t.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var f frame
+		if err := json.Unmarshal(msg, &f); err != nil || f.ID == nil || *f.ID != 0 {
+			queued = append(queued, msg)
+			continue
+		}
+
+		resp := QSCStatusGetResponse{}
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			return fmt.Errorf("unable to parse response: %w", err)
+		}
+
+		// cores that require a logon answer with an error until we have logged on,
+		// so the prompt is left empty and the engine status isn't known until the core sends one
+		prompt := []byte{}
+		if resp.Error == nil {
+			prompt, err = json.Marshal(QSCStatusReport{JSONRPC: "2.0", Method: "EngineStatus", Params: resp.Result})
+			if err != nil {
+				return err
+			}
+		}
+
+		t.queued = append([][]byte{prompt}, queued...)
+		return nil
+	}
+}
+
+func (t *wsTransport) ReadFrame() ([]byte, error) {
+	if len(t.queued) > 0 {
+		frame := t.queued[0]
+		t.queued = t.queued[1:]
+		return frame, nil
+	}
+
+	_, msg, err := t.conn.ReadMessage()
+	return msg, err
+}
+
+func (t *wsTransport) WriteFrame(frame []byte, deadline time.Time) error {
+	t.conn.SetWriteDeadline(deadline)
+	return t.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (t *wsTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}